@@ -1,74 +1,321 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"io/fs"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
 
+	"github.com/haiyen11231/eco-taxi-api-gateway/internal/apikey"
+	"github.com/haiyen11231/eco-taxi-api-gateway/internal/auth"
+	"github.com/haiyen11231/eco-taxi-api-gateway/internal/backend"
+	"github.com/haiyen11231/eco-taxi-api-gateway/internal/config"
 	"github.com/haiyen11231/eco-taxi-api-gateway/internal/handler"
+	"github.com/haiyen11231/eco-taxi-api-gateway/internal/logger"
+	"github.com/haiyen11231/eco-taxi-api-gateway/internal/loginguard"
 	"github.com/haiyen11231/eco-taxi-api-gateway/internal/middleware"
+	"github.com/haiyen11231/eco-taxi-api-gateway/internal/notify"
+	"github.com/haiyen11231/eco-taxi-api-gateway/internal/telemetry"
+	"github.com/haiyen11231/eco-taxi-api-gateway/internal/utils"
+	"github.com/haiyen11231/eco-taxi-api-gateway/internal/verification"
 
-	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"google.golang.org/grpc"
 )
 
 func main() {
-	loadEnv() // Loads environment variables from the .env file
-
-	r := gin.Default() // Creates a new Gin router with default middleware
-
-    r.Use(cors.New(cors.Config{
-        AllowOrigins:     []string{"http://localhost:5173"}, // Allow your frontend origin
-        AllowMethods:     []string{"GET", "POST", "PATCH", "DELETE", "OPTIONS"}, // Allowed methods
-        AllowHeaders:     []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"}, // Allowed headers
-        ExposeHeaders:    []string{"Link"}, // Headers exposed to the frontend
-        AllowCredentials: true, // Allows cookies or Authorization headers
-        MaxAge:           300, // Cache duration for preflight responses
-    }))
-
-    v1 := r.Group("/v1")
-
-    v1.GET("/ping", func(ctx *gin.Context) {
-        ctx.JSON(http.StatusAccepted, gin.H{"ok": true})
-   })
-
-    user := v1.Group("/user")
-    user.POST("/signup", handler.SignUp()) 
-    user.POST("/login", handler.LogIn()) 
-    user.PATCH("/reset-password", handler.ForgotPassword())
-    user.POST("/refresh-token", handler.RefreshToken()) 
-    user.Use(middleware.AuthenticateUser)
-    user.PATCH("/update", handler.UpdateUser()) 
-    user.GET("/", handler.GetUser()) 
-    user.PATCH("/change-password", handler.ChangePassword())
-    user.PATCH("/update-distance", handler.UpdateDistanceTravelled())
-    user.POST("/authenticate", handler.AuthenticateUser()) 
-    user.DELETE("/logout", handler.LogOut()) 
-
-    trip := v1.Group("/trip")
-    trip.POST("", handler.SearchTripPreview())
-    trip.Use(middleware.AuthenticateUser) 
-    trip.POST("/confirm", handler.ConfirmBooking())
-    trip.GET("/incompleted-booking", handler.GetIncompletedBooking())
-    trip.PATCH("/:id", handler.UpdateBookingStatus())
-    trip.GET("/history", handler.GetBookingHistory())
-    
-    payment := v1.Group("/payment")
-    payment.Use(middleware.AuthenticateUser)
-    payment.GET("/", handler.GetCards())
-    payment.POST("/create", handler.CreateCard()) 
-    payment.PATCH("/:id", handler.UpdateCard()) 
-    payment.DELETE("/:id", handler.DeleteCard())
-
-    r.Run(fmt.Sprintf(":%s", os.Getenv("PORT")))
+	loadEnv() // Loads environment variables from the .env file, when there is one
 
+	// Merging the defaults, the config file, the environment and the flags, and validating the result
+	cfg, err := config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Switching every log line to structured JSON with sensitive values redacted
+	if err := logger.Setup(os.Stdout, cfg.Log.Level); err != nil {
+		log.Fatalf("Invalid log level: %v", err)
+	}
+
+	// Setting up tracing before anything creates spans
+	shutdownTracing, err := telemetry.Setup(context.Background(), telemetry.Config{
+		Exporter:     cfg.Tracing.Exporter,
+		ServiceName:  cfg.Tracing.ServiceName,
+		OTLPEndpoint: cfg.Tracing.OTLPEndpoint,
+		OTLPInsecure: cfg.Tracing.OTLPInsecure,
+	})
+	if err != nil {
+		log.Fatal("Error setting up tracing: ", err)
+	}
+	defer func() {
+		c, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		shutdownTracing(c)
+	}()
+
+	// Creating the shared gRPC clients once; every handler reuses the same connections
+	backends, err := backend.NewRegistry(cfg.BackendConfig(),
+		grpc.WithStatsHandler(otelgrpc.NewClientHandler()),              // Client spans for every backend call
+		grpc.WithChainUnaryInterceptor(telemetry.GRPCClientInterceptor), // Client metrics for every call attempt
+	)
+	if err != nil {
+		log.Fatal("Error creating gRPC clients: ", err)
+	}
+	defer backends.Close()
+
+	telemetry.RegisterConnectionPool(backends.ConnectionStates)
+
+	// Connecting to Redis, which keeps the state the instances of a cluster share
+	var redisClient *redis.Client
+	if cfg.Redis.Addr != "" {
+		redisClient = redis.NewClient(&redis.Options{
+			Addr:     cfg.Redis.Addr,
+			Password: cfg.Redis.Password,
+			DB:       cfg.Redis.DB,
+		})
+		defer redisClient.Close()
+
+		if err := redisClient.Ping(context.Background()).Err(); err != nil {
+			slog.Warn("Redis is unreachable", "addr", cfg.Redis.Addr, "error", err)
+		}
+	}
+
+	// Rotating refresh tokens in the gateway, as the user service's don't change when used
+	var sessionStore auth.SessionStore = auth.NewMemorySessionStore()
+	if cfg.Auth.Session.Store == "redis" {
+		sessionStore = auth.NewRedisSessionStore(redisClient, cfg.Redis.KeyPrefix+"session:")
+	}
+	sessions := auth.NewSessions(cfg.SessionConfig(), sessionStore)
+
+	// Verifying access tokens in the gateway, or with the user service in remote mode. Remote checks
+	// are cached. A user's sessions and cache entries are dropped when they log out or change password.
+	var authenticator auth.Authenticator = auth.NewRemote(backends.User)
+	invalidator := auth.UserInvalidators{sessions}
+	switch {
+	case cfg.Auth.Mode == auth.ModeLocal:
+		authenticator, err = auth.NewJWT(context.Background(), auth.JWTConfig(cfg.Auth.JWT))
+		if err != nil {
+			log.Fatal("Error setting up JWT verification: ", err)
+		}
+	case cfg.Auth.Cache.TTL > 0:
+		cache := auth.NewCache(authenticator, auth.CacheConfig(cfg.Auth.Cache))
+		authenticator = cache
+		invalidator = append(invalidator, cache)
+	}
+
+	// Throttling failed logins, with the counts shared through Redis when running several instances
+	var loginStore loginguard.Store = loginguard.NewMemoryStore()
+	if cfg.Auth.LoginGuard.Store == "redis" {
+		loginStore = loginguard.NewRedisStore(redisClient, cfg.Redis.KeyPrefix+"login:")
+	}
+	guard := loginguard.New(cfg.LoginGuardConfig(), loginStore)
+
+	// Issuing the one-time codes that confirm phone numbers and password resets, and delivering them
+	var codeStore verification.Store = verification.NewMemoryStore()
+	if cfg.Verification.Store == "redis" {
+		codeStore = verification.NewRedisStore(redisClient, cfg.Redis.KeyPrefix+"verification:")
+	}
+	verifier, err := verification.New(cfg.VerificationConfig(), codeStore)
+	if err != nil {
+		log.Fatal("Error setting up verification codes: ", err)
+	}
+
+	notifier, sms := newNotifier(cfg.Notifier), newNotifier(cfg.SMS)
+
+	// Loading the hashed API keys issued to partners
+	apiKeyStore, err := apikey.NewFileStore(cfg.APIKeys.File)
+	if err != nil {
+		log.Fatal("Error loading API keys: ", err)
+	}
+	apiKeys := apikey.New(apiKeyStore)
+
+	// Choosing between RFC 7807 problem+json errors and the legacy {code, error, message} shape
+	utils.LegacyErrors = cfg.Server.ErrorFormat == "legacy"
+	utils.UseJSONFieldNames()
+
+	r := gin.New() // Creates a new Gin router; request logging is done by middleware.Logger
+	r.Use(gin.CustomRecovery(func(ctx *gin.Context, _ any) {
+		utils.ResponseError(ctx, http.StatusInternalServerError, "Internal server error")
+	}))
+
+	// Letting *gin.Context resolve values stored on the request context, such as the request logger
+	r.ContextWithFallback = true
+
+	// Taking the client IP from X-Forwarded-For only when the configured proxies set it
+	if err := r.SetTrustedProxies(cfg.Server.TrustedProxies); err != nil {
+		log.Fatalf("Invalid trusted proxies: %v", err)
+	}
+
+	// Server spans named after the route template, continuing any incoming traceparent
+	r.Use(otelgin.Middleware(cfg.Tracing.ServiceName))
+
+	// Applying the CORS policy configured for the request's route group
+	r.Use(middleware.CORS(cfg.CORSPolicies()))
+
+	// Recording request metrics by route template
+	r.Use(middleware.Metrics())
+
+	// Tagging every request with an ID that is echoed to the client and forwarded to the backends
+	r.Use(middleware.RequestID())
+	r.Use(middleware.Logger())
+
+	// Bounding every request; backend calls inherit the deadline and are cancelled if the client goes away
+	r.Use(middleware.Deadline(middleware.DeadlineConfig{
+		Default: cfg.Server.RequestTimeout,
+		Routes:  cfg.Server.RouteTimeouts,
+	}))
+
+	// Prometheus metrics endpoint
+	r.GET("/metrics", gin.WrapH(promhttp.Handler()))
+
+	// Liveness and readiness probes for the orchestrator; readiness fails while draining on shutdown
+	var draining atomic.Bool
+	r.GET("/healthz", handler.Liveness())
+	r.GET("/readyz", handler.Readiness(backends, 2*time.Second, &draining))
+
+	// Authenticating the caller, then checking the roles and scopes configured for the route. Groups
+	// can declare more with middleware.RequireRoles and middleware.RequireScopes.
+	authenticateUser := middleware.AuthenticateUser(authenticator)
+	authorize := middleware.Authorize(cfg.AccessPolicies())
+	authenticate := []gin.HandlerFunc{authenticateUser, authorize}
+
+	// Letting partners call the trip routes server-to-server with an API key instead of a rider's token
+	authenticatePartner := []gin.HandlerFunc{middleware.AuthenticateAPIKey(apiKeys, authenticateUser), authorize}
+
+	v1 := r.Group("/v1")
+
+	v1.GET("/ping", func(ctx *gin.Context) {
+		ctx.JSON(http.StatusAccepted, gin.H{"ok": true})
+	})
+
+	user := v1.Group("/user")
+	user.POST("/signup", handler.SignUp(verifier, sms))
+	user.POST("/verify-phone", handler.VerifyPhone(backends.User, verifier))
+	user.POST("/verify-phone/resend", handler.ResendPhoneCode(verifier, sms))
+	user.POST("/login", handler.LogIn(backends.User, sessions, guard))
+	user.POST("/reset-password", handler.RequestPasswordReset(verifier, notifier, cfg.Verification.PasswordResetLinkURL))
+	user.POST("/reset-password/confirm", handler.ConfirmPasswordReset(backends.User, verifier, invalidator))
+	user.POST("/refresh-token", handler.RefreshToken(backends.User, sessions))
+	user.Use(authenticate...)
+	user.PATCH("/update", handler.UpdateUser(backends.User))
+	user.GET("/", handler.GetUser(backends.User))
+	user.PATCH("/change-password", handler.ChangePassword(backends.User, invalidator))
+	user.PATCH("/update-distance", handler.UpdateDistanceTravelled(backends.User))
+	user.POST("/authenticate", handler.AuthenticateUser(backends.User))
+	user.DELETE("/logout", handler.LogOut(backends.User, sessions, invalidator))
+
+	trip := v1.Group("/trip")
+	trip.POST("", handler.SearchTripPreview(backends.Trip))
+	trip.Use(authenticatePartner...)
+	trip.POST("/confirm", handler.ConfirmBooking(backends.Trip))
+	trip.GET("/incompleted-booking", handler.GetIncompletedBooking(backends.Trip))
+	trip.PATCH("/:id", handler.UpdateBookingStatus(backends.Trip))
+	trip.GET("/history", handler.GetBookingHistory(backends.Trip))
+
+	payment := v1.Group("/payment")
+	payment.Use(authenticate...)
+	payment.GET("/", handler.GetCards(backends.Payment))
+	payment.POST("/create", handler.CreateCard(backends.Payment))
+	payment.PATCH("/:id", handler.UpdateCard(backends.Payment))
+	payment.DELETE("/:id", handler.DeleteCard(backends.Payment))
+
+	r.NoRoute(func(ctx *gin.Context) {
+		utils.ResponseError(ctx, http.StatusNotFound, "Route not found")
+	})
+
+	// Serving operator endpoints on a separate address that clients can't reach
+	admin := gin.New()
+	admin.Use(gin.Recovery())
+	admin.ContextWithFallback = true
+	admin.Use(middleware.RequestID())
+	admin.Use(middleware.Logger())
+	// Requiring the admin token on every endpoint, as they change the log level, profile the process and
+	// manage the API keys
+	admin.Use(middleware.AuthenticateAdmin(cfg.Admin.Token))
+	admin.GET("/config", handler.EffectiveConfig(cfg))
+	admin.GET("/routes", handler.Routes(r))
+	admin.GET("/backends", handler.BackendState(backends))
+	admin.GET("/log-level", handler.GetLogLevel())
+	admin.PUT("/log-level", handler.SetLogLevel())
+	admin.GET("/api-keys", handler.ListAPIKeys(apiKeys))
+	admin.POST("/api-keys", handler.CreateAPIKey(apiKeys))
+	admin.POST("/api-keys/:id/rotate", handler.RotateAPIKey(apiKeys))
+	admin.DELETE("/api-keys/:id", handler.RevokeAPIKey(apiKeys))
+	admin.Any("/debug/pprof/*profile", handler.Pprof())
+	adminServer := &http.Server{
+		Addr:    cfg.Admin.Addr,
+		Handler: admin.Handler(),
+	}
+	go serve(adminServer, "admin")
+
+	server := &http.Server{
+		Addr:         fmt.Sprintf(":%s", cfg.Server.Port),
+		Handler:      r.Handler(),
+		ReadTimeout:  cfg.Server.ReadTimeout,
+		WriteTimeout: cfg.Server.WriteTimeout,
+		IdleTimeout:  cfg.Server.IdleTimeout,
+	}
+	go serve(server, "API")
+
+	// Waiting for the orchestrator to stop the gateway
+	stopped, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	<-stopped.Done()
+
+	// Failing readiness first, so load balancers stop sending new requests before the listener closes
+	slog.Info("Shutting down, draining connections", "drain_delay", cfg.Server.DrainDelay.String())
+	draining.Store(true)
+	server.SetKeepAlivesEnabled(false)
+	time.Sleep(cfg.Server.DrainDelay)
+
+	// Stopping accepting requests and waiting for the in-flight ones; the deferred calls then close the
+	// backend connections and flush the remaining spans
+	c, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownGracePeriod)
+	defer cancel()
+	if err := server.Shutdown(c); err != nil {
+		slog.Error("In-flight requests did not finish within the grace period", "error", err)
+	}
+	if err := adminServer.Shutdown(c); err != nil {
+		slog.Error("Failed to shut down admin server", "error", err)
+	}
+	slog.Info("Server stopped")
+}
+
+// serve runs srv until it is shut down, exiting the process if it fails to listen
+func serve(srv *http.Server, name string) {
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Fatalf("Error starting %s server: %v", name, err)
+	}
+}
+
+// newNotifier returns the sink delivering a channel of messages to users. Email and SMS providers plug in here.
+func newNotifier(cfg config.NotifierConfig) notify.Notifier {
+	if cfg.Sink == notify.SinkFile {
+		return notify.NewFile(cfg.File)
+	}
+	return notify.Log{Bodies: cfg.LogBodies}
 }
 
 func loadEnv() {
 	err := godotenv.Load("app.env")
-	if err != nil {
-		log.Fatal("Error loading .env file")
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Fatal("Error loading .env file: ", err)
 	}
-}
\ No newline at end of file
+}
@@ -0,0 +1,245 @@
+package apikey
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Role is the role of every caller authenticated with an API key, so routes can be opened to partners
+// with a role policy.
+const Role = "partner"
+
+// prefix starts every API key, so leaked keys are easy to spot
+const prefix = "eco_"
+
+// DefaultRateLimit is the rate limit of keys created without one, in requests per minute.
+const DefaultRateLimit = 60
+
+// ErrInvalidKey is returned for API keys that are malformed, unknown, expired or revoked.
+var ErrInvalidKey = errors.New("invalid API key")
+
+// Key is an API key issued to a partner. The secret itself is only shown when the key is created or rotated.
+type Key struct {
+	ID    string `json:"id"`
+	Owner string `json:"owner"`
+	// AccountID is the partner's account in the user service, the rider the key books and manages trips
+	// for. Keys issued before it existed have none and can't reach the user-scoped routes.
+	AccountID uint64 `json:"account_id,omitempty"`
+	// Scopes are granted to the caller, like the scopes of an access token.
+	Scopes []string `json:"scopes"`
+	// RateLimit is the number of requests per minute the key may make.
+	RateLimit int        `json:"rate_limit"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+
+	// Hash is the SHA-256 of the secret. After a rotation, PreviousHash keeps the old secret working
+	// until PreviousExpiresAt, so the partner can switch over without downtime.
+	Hash              string     `json:"hash"`
+	PreviousHash      string     `json:"previous_hash,omitempty"`
+	PreviousExpiresAt *time.Time `json:"previous_expires_at,omitempty"`
+}
+
+// Active reports whether the key can still be used.
+func (k Key) Active(now time.Time) bool {
+	return k.RevokedAt == nil && (k.ExpiresAt == nil || now.Before(*k.ExpiresAt))
+}
+
+// matches reports whether the secret is the key's current one, or its previous one during a rotation
+func (k Key) matches(secret string, now time.Time) bool {
+	hash := hashSecret(secret)
+	if subtle.ConstantTimeCompare([]byte(hash), []byte(k.Hash)) == 1 {
+		return true
+	}
+	return k.PreviousHash != "" && k.PreviousExpiresAt != nil && now.Before(*k.PreviousExpiresAt) &&
+		subtle.ConstantTimeCompare([]byte(hash), []byte(k.PreviousHash)) == 1
+}
+
+// NewKey is what a new key is issued with.
+type NewKey struct {
+	Owner     string
+	AccountID uint64
+	Scopes    []string
+	RateLimit int
+	ExpiresAt *time.Time
+}
+
+// Keys issues, checks and rate limits API keys.
+type Keys struct {
+	store Store
+
+	mu       sync.Mutex
+	limiters map[string]*rate.Limiter // by key ID
+}
+
+// New returns the API keys held by store.
+func New(store Store) *Keys {
+	return &Keys{store: store, limiters: map[string]*rate.Limiter{}}
+}
+
+// Create issues a key and returns it with its secret, which can't be retrieved later.
+func (k *Keys) Create(ctx context.Context, n NewKey) (Key, string, error) {
+	id, err := randomString(8, hex.EncodeToString)
+	if err != nil {
+		return Key{}, "", err
+	}
+	secret, err := randomString(32, base64.RawURLEncoding.EncodeToString)
+	if err != nil {
+		return Key{}, "", err
+	}
+
+	key := Key{
+		ID:        id,
+		Owner:     n.Owner,
+		AccountID: n.AccountID,
+		Scopes:    n.Scopes,
+		RateLimit: n.RateLimit,
+		CreatedAt: time.Now().UTC(),
+		ExpiresAt: n.ExpiresAt,
+		Hash:      hashSecret(secret),
+	}
+	if key.RateLimit <= 0 {
+		key.RateLimit = DefaultRateLimit
+	}
+	if key.Scopes == nil {
+		key.Scopes = []string{}
+	}
+
+	if err := k.store.Save(ctx, key); err != nil {
+		return Key{}, "", err
+	}
+	return key, format(id, secret), nil
+}
+
+// List returns every key.
+func (k *Keys) List(ctx context.Context) ([]Key, error) {
+	return k.store.List(ctx)
+}
+
+// Rotate gives the key a new secret, which it returns. The old secret keeps working for grace.
+func (k *Keys) Rotate(ctx context.Context, id string, grace time.Duration) (Key, string, error) {
+	key, err := k.store.Get(ctx, id)
+	if err != nil {
+		return Key{}, "", err
+	}
+	if !key.Active(time.Now()) {
+		return Key{}, "", ErrInvalidKey
+	}
+
+	secret, err := randomString(32, base64.RawURLEncoding.EncodeToString)
+	if err != nil {
+		return Key{}, "", err
+	}
+
+	key.PreviousHash, key.PreviousExpiresAt = "", nil
+	if grace > 0 {
+		until := time.Now().UTC().Add(grace)
+		key.PreviousHash, key.PreviousExpiresAt = key.Hash, &until
+	}
+	key.Hash = hashSecret(secret)
+
+	if err := k.store.Save(ctx, key); err != nil {
+		return Key{}, "", err
+	}
+	return key, format(id, secret), nil
+}
+
+// Revoke stops the key from working. Revoked keys are kept, so they still show up in the list.
+func (k *Keys) Revoke(ctx context.Context, id string) (Key, error) {
+	key, err := k.store.Get(ctx, id)
+	if err != nil {
+		return Key{}, err
+	}
+	if key.RevokedAt != nil {
+		return key, nil
+	}
+
+	now := time.Now().UTC()
+	key.RevokedAt = &now
+	if err := k.store.Save(ctx, key); err != nil {
+		return Key{}, err
+	}
+
+	k.mu.Lock()
+	delete(k.limiters, id)
+	k.mu.Unlock()
+	return key, nil
+}
+
+// Authenticate returns the key a presented API key belongs to, or ErrInvalidKey.
+func (k *Keys) Authenticate(ctx context.Context, presented string) (Key, error) {
+	id, secret, ok := parse(presented)
+	if !ok {
+		return Key{}, ErrInvalidKey
+	}
+
+	key, err := k.store.Get(ctx, id)
+	if errors.Is(err, ErrNotFound) {
+		return Key{}, ErrInvalidKey
+	}
+	if err != nil {
+		return Key{}, err
+	}
+
+	now := time.Now()
+	if !key.Active(now) || !key.matches(secret, now) {
+		return Key{}, ErrInvalidKey
+	}
+	return key, nil
+}
+
+// Allow takes one request from the key's rate limit. When the limit is used up, it returns false and how
+// long until the next request is allowed. Keys may burst up to a minute's worth of requests.
+func (k *Keys) Allow(key Key) (bool, time.Duration) {
+	k.mu.Lock()
+	limiter, ok := k.limiters[key.ID]
+	if !ok || limiter.Burst() != key.RateLimit {
+		limiter = rate.NewLimiter(rate.Every(time.Minute/time.Duration(key.RateLimit)), key.RateLimit)
+		k.limiters[key.ID] = limiter
+	}
+	k.mu.Unlock()
+
+	reservation := limiter.Reserve()
+	if delay := reservation.Delay(); delay > 0 {
+		reservation.Cancel()
+		return false, delay
+	}
+	return true, 0
+}
+
+// format builds the key handed to the partner: the prefix, the key ID and the secret
+func format(id, secret string) string {
+	return prefix + id + "_" + secret
+}
+
+func parse(presented string) (id, secret string, ok bool) {
+	rest, ok := strings.CutPrefix(presented, prefix)
+	if !ok {
+		return "", "", false
+	}
+	id, secret, ok = strings.Cut(rest, "_")
+	return id, secret, ok && id != "" && secret != ""
+}
+
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomString(n int, encode func([]byte) string) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return encode(b), nil
+}
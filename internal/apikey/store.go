@@ -0,0 +1,132 @@
+package apikey
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// ErrNotFound is returned for key IDs the store doesn't hold.
+var ErrNotFound = errors.New("API key not found")
+
+// Store persists API keys. Keys only ever hold the hashes of their secrets.
+type Store interface {
+	// Get returns the key with the ID, or ErrNotFound.
+	Get(ctx context.Context, id string) (Key, error)
+	// List returns every key, revoked ones included.
+	List(ctx context.Context) ([]Key, error)
+	// Save creates or replaces a key.
+	Save(ctx context.Context, key Key) error
+}
+
+// FileStore keeps the keys in memory and in a JSON file, which is rewritten on every change. It suits the
+// small number of partner keys a single gateway instance serves.
+type FileStore struct {
+	path string
+
+	mu   sync.RWMutex
+	keys map[string]Key
+}
+
+// NewFileStore loads the keys from the file at path. A missing file is an empty store; it is created on
+// the first change.
+func NewFileStore(path string) (*FileStore, error) {
+	s := &FileStore{path: path, keys: map[string]Key{}}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []Key
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	for _, key := range keys {
+		s.keys[key.ID] = key
+	}
+	return s, nil
+}
+
+func (s *FileStore) Get(_ context.Context, id string) (Key, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	key, ok := s.keys[id]
+	if !ok {
+		return Key{}, ErrNotFound
+	}
+	return key, nil
+}
+
+func (s *FileStore) List(_ context.Context) ([]Key, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.sorted(), nil
+}
+
+func (s *FileStore) Save(_ context.Context, key Key) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	previous, existed := s.keys[key.ID]
+	s.keys[key.ID] = key
+
+	if err := s.write(); err != nil {
+		// Keeping memory in line with the file, so a failed change isn't half applied
+		if existed {
+			s.keys[key.ID] = previous
+		} else {
+			delete(s.keys, key.ID)
+		}
+		return err
+	}
+	return nil
+}
+
+// sorted must be called with s.mu held
+func (s *FileStore) sorted() []Key {
+	keys := make([]Key, 0, len(s.keys))
+	for _, key := range s.keys {
+		keys = append(keys, key)
+	}
+	slices.SortFunc(keys, func(a, b Key) int {
+		return strings.Compare(a.ID, b.ID)
+	})
+	return keys
+}
+
+// write replaces the file through a rename, so a crash never leaves it half written. It must be called
+// with s.mu held.
+func (s *FileStore) write() error {
+	data, err := json.MarshalIndent(s.sorted(), "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
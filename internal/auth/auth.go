@@ -0,0 +1,91 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+
+	"github.com/haiyen11231/eco-taxi-api-gateway/internal/backend"
+	"github.com/haiyen11231/eco-taxi-api-gateway/internal/grpc/pb"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// Authentication modes accepted by the gateway configuration.
+const (
+	ModeLocal  = "local"
+	ModeRemote = "remote"
+)
+
+// ErrInvalidToken is returned for access tokens that are malformed, expired or not issued for the gateway.
+// Any other error means the token could not be checked.
+var ErrInvalidToken = errors.New("invalid token")
+
+// Identity is the caller an access token was issued to.
+type Identity struct {
+	UserID uint64
+	// Roles, such as "admin" or "driver", and scopes, such as "trips:write", the token grants.
+	Roles  []string
+	Scopes []string
+}
+
+// HasRole reports whether the identity has the role.
+func (i Identity) HasRole(role string) bool {
+	return slices.Contains(i.Roles, role)
+}
+
+// HasScope reports whether the identity has the scope.
+func (i Identity) HasScope(scope string) bool {
+	return slices.Contains(i.Scopes, scope)
+}
+
+// Authenticator checks access tokens.
+type Authenticator interface {
+	Authenticate(ctx context.Context, token string) (Identity, error)
+}
+
+// Remote authenticates tokens with the user service's AuthenticateUser RPC.
+type Remote struct {
+	client pb.UserServiceClient
+}
+
+// NewRemote returns an authenticator that checks every token with the user service.
+func NewRemote(client pb.UserServiceClient) *Remote {
+	return &Remote{client: client}
+}
+
+// Authenticate checks the token. The user's roles and scopes are read from the response metadata, as the
+// AuthenticateUser response has no fields for them.
+func (r *Remote) Authenticate(ctx context.Context, token string) (Identity, error) {
+	var header metadata.MD
+	response, err := r.client.AuthenticateUser(ctx, &pb.AuthenticateUserRequest{
+		Token: token,
+	}, grpc.Header(&header))
+	if err != nil {
+		return Identity{}, err
+	}
+
+	if !response.IsValid {
+		return Identity{}, ErrInvalidToken
+	}
+	return Identity{
+		UserID: response.UserId,
+		Roles:  splitMetadata(header.Get(backend.UserRolesHeader)),
+		Scopes: splitMetadata(header.Get(backend.UserScopesHeader)),
+	}, nil
+}
+
+// splitMetadata reads a list sent either as comma-separated values or as repeated keys
+func splitMetadata(values []string) []string {
+	var items []string
+	for _, value := range values {
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+	}
+	return items
+}
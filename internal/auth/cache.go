@@ -0,0 +1,177 @@
+package auth
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// invalidationRetention is how long an invalidation keeps checks that started before it from being cached
+const invalidationRetention = time.Minute
+
+// UserInvalidator forgets what is known about a user's tokens, e.g. after they log out or change password.
+type UserInvalidator interface {
+	InvalidateUser(ctx context.Context, userID uint64) error
+}
+
+// UserInvalidators invalidates a user in each of its elements, such as the cache and the refresh sessions.
+// Every element is invalidated even when an earlier one fails.
+type UserInvalidators []UserInvalidator
+
+func (i UserInvalidators) InvalidateUser(ctx context.Context, userID uint64) error {
+	var errs []error
+	for _, invalidator := range i {
+		if err := invalidator.InvalidateUser(ctx, userID); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// CacheConfig bounds the authentication cache.
+type CacheConfig struct {
+	// TTL is how long a successful check is reused. It also bounds how long a token revoked by another
+	// gateway instance keeps being accepted here.
+	TTL time.Duration
+	// MaxEntries is the number of tokens kept; the least recently used ones are evicted first.
+	MaxEntries int
+}
+
+// Cache remembers successful checks of an Authenticator, keyed by the SHA-256 of the token, and collapses
+// concurrent checks of the same token into one. Invalid tokens and errors are not cached.
+type Cache struct {
+	next  Authenticator
+	ttl   time.Duration
+	max   int
+	group singleflight.Group
+
+	mu      sync.Mutex
+	entries map[string]*list.Element // by token hash; values are *cacheEntry
+	lru     *list.List               // most recently used first
+	byUser  map[uint64]map[string]struct{}
+	// invalidated records when each user was last invalidated, so checks that started earlier aren't cached
+	invalidated map[uint64]time.Time
+}
+
+type cacheEntry struct {
+	key      string
+	identity Identity
+	expires  time.Time
+}
+
+// NewCache wraps next with a cache.
+func NewCache(next Authenticator, cfg CacheConfig) *Cache {
+	return &Cache{
+		next:        next,
+		ttl:         cfg.TTL,
+		max:         cfg.MaxEntries,
+		entries:     map[string]*list.Element{},
+		lru:         list.New(),
+		byUser:      map[uint64]map[string]struct{}{},
+		invalidated: map[uint64]time.Time{},
+	}
+}
+
+func (c *Cache) Authenticate(ctx context.Context, token string) (Identity, error) {
+	key := hashToken(token)
+
+	if identity, ok := c.get(key); ok {
+		return identity, nil
+	}
+
+	result, err, _ := c.group.Do(key, func() (any, error) {
+		started := time.Now()
+
+		// Not bound to the first caller, so its client going away doesn't fail the others waiting on the check
+		identity, err := c.next.Authenticate(context.WithoutCancel(ctx), token)
+		if err != nil {
+			return Identity{}, err
+		}
+
+		c.put(key, identity, started)
+		return identity, nil
+	})
+	return result.(Identity), err
+}
+
+func (c *Cache) get(key string) (Identity, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.entries[key]
+	if !ok {
+		return Identity{}, false
+	}
+
+	entry := element.Value.(*cacheEntry)
+	if time.Now().After(entry.expires) {
+		c.remove(element)
+		return Identity{}, false
+	}
+
+	c.lru.MoveToFront(element)
+	return entry.identity, true
+}
+
+func (c *Cache) put(key string, identity Identity, started time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// The user logged out while the check was in flight, so its result may already be stale
+	if invalidated, ok := c.invalidated[identity.UserID]; ok && !started.After(invalidated) {
+		return
+	}
+
+	if element, ok := c.entries[key]; ok {
+		c.remove(element)
+	}
+
+	entry := &cacheEntry{key: key, identity: identity, expires: time.Now().Add(c.ttl)}
+	c.entries[key] = c.lru.PushFront(entry)
+	if c.byUser[identity.UserID] == nil {
+		c.byUser[identity.UserID] = map[string]struct{}{}
+	}
+	c.byUser[identity.UserID][key] = struct{}{}
+
+	for c.lru.Len() > c.max {
+		c.remove(c.lru.Back())
+	}
+}
+
+// InvalidateUser drops every cached token of the user, so the next request checks its token again.
+func (c *Cache) InvalidateUser(_ context.Context, userID uint64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key := range c.byUser[userID] {
+		c.remove(c.entries[key])
+	}
+
+	now := time.Now()
+	c.invalidated[userID] = now
+
+	// Checks are bounded by the RPC timeout, so older invalidations can't affect one in flight anymore
+	for id, at := range c.invalidated {
+		if now.Sub(at) > invalidationRetention {
+			delete(c.invalidated, id)
+		}
+	}
+	return nil
+}
+
+// remove must be called with c.mu held
+func (c *Cache) remove(element *list.Element) {
+	entry := element.Value.(*cacheEntry)
+	c.lru.Remove(element)
+	delete(c.entries, entry.key)
+
+	keys := c.byUser[entry.identity.UserID]
+	delete(keys, entry.key)
+	if len(keys) == 0 {
+		delete(c.byUser, entry.identity.UserID)
+	}
+}
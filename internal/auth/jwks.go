@@ -0,0 +1,252 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	defaultJWKSRefreshInterval = 5 * time.Minute
+	// minJWKSReload limits reloads triggered by tokens signed with an unknown kid
+	minJWKSReload = 30 * time.Second
+)
+
+// keysUnavailableError means the JWKS has never been loaded, so no RSA or EC token can be checked.
+type keysUnavailableError struct {
+	err error
+}
+
+func (e *keysUnavailableError) Error() string {
+	return fmt.Sprintf("JWKS unavailable: %v", e.err)
+}
+
+func (e *keysUnavailableError) Unwrap() error {
+	return e.err
+}
+
+// keySet holds the public keys of a JWKS, by kid. It reloads them periodically, and early when a token
+// names a kid it doesn't know, which is how a key rotation first shows up.
+type keySet struct {
+	url     string
+	file    string
+	refresh time.Duration
+	client  *http.Client
+
+	mu         sync.RWMutex
+	keys       map[string]any // *rsa.PublicKey or *ecdsa.PublicKey
+	loadedAt   time.Time
+	attemptAt  time.Time
+	reloadLock sync.Mutex
+}
+
+func newKeySet(url, file string, refresh time.Duration) *keySet {
+	if refresh <= 0 {
+		refresh = defaultJWKSRefreshInterval
+	}
+	return &keySet{
+		url:     url,
+		file:    file,
+		refresh: refresh,
+		client:  &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+// get returns the key with the given kid. A token without a kid can only be checked when the set has a single key.
+func (s *keySet) get(ctx context.Context, kid string) (any, error) {
+	s.mu.RLock()
+	key, ok := s.lookup(kid)
+	stale := time.Since(s.loadedAt) > s.refresh
+	s.mu.RUnlock()
+
+	if ok && !stale {
+		return key, nil
+	}
+
+	s.reload(ctx, !ok)
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if key, ok := s.lookup(kid); ok {
+		return key, nil
+	}
+	if s.keys == nil {
+		return nil, &keysUnavailableError{err: errors.New("no keys loaded")}
+	}
+	return nil, fmt.Errorf("unknown key %q", kid)
+}
+
+// lookup must be called with s.mu held
+func (s *keySet) lookup(kid string) (any, bool) {
+	if kid == "" && len(s.keys) == 1 {
+		for _, key := range s.keys {
+			return key, true
+		}
+	}
+	key, ok := s.keys[kid]
+	return key, ok
+}
+
+// reload refreshes the keys, keeping the previous ones when it fails. Only one reload runs at a time,
+// and reloads for unknown kids are rate limited so bogus tokens can't hammer the JWKS endpoint.
+func (s *keySet) reload(ctx context.Context, unknownKid bool) {
+	s.reloadLock.Lock()
+	defer s.reloadLock.Unlock()
+
+	s.mu.RLock()
+	fresh := time.Since(s.loadedAt) <= s.refresh
+	recent := time.Since(s.attemptAt) < minJWKSReload
+	s.mu.RUnlock()
+	if (fresh && !unknownKid) || recent {
+		return
+	}
+
+	if err := s.load(ctx); err != nil {
+		slog.Error("Failed to reload JWKS, keeping the previous keys", "error", err)
+	}
+}
+
+// load fetches and parses the key set, replacing the current keys on success
+func (s *keySet) load(ctx context.Context) error {
+	s.mu.Lock()
+	s.attemptAt = time.Now()
+	s.mu.Unlock()
+
+	data, err := s.fetch(ctx)
+	if err != nil {
+		return err
+	}
+
+	keys, err := parseJWKS(data)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.keys = keys
+	s.loadedAt = time.Now()
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *keySet) fetch(ctx context.Context) ([]byte, error) {
+	if s.file != "" {
+		return os.ReadFile(s.file)
+	}
+
+	// Not bound to the request, so a client going away doesn't abort a reload other requests wait for
+	c, cancel := context.WithTimeout(context.WithoutCancel(ctx), s.client.Timeout)
+	defer cancel()
+
+	request, err := http.NewRequestWithContext(c, http.MethodGet, s.url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := s.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", s.url, response.Status)
+	}
+	return io.ReadAll(io.LimitReader(response.Body, 1<<20))
+}
+
+// jwk is a JSON Web Key, limited to the RSA and P-256 EC signing keys the gateway accepts
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// parseJWKS returns the signing keys of a JWKS document by kid, skipping keys of other types or uses
+func parseJWKS(data []byte) (map[string]any, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("parsing JWKS: %w", err)
+	}
+
+	keys := map[string]any{}
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+
+		key, err := k.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", k.Kid, err)
+		}
+		if key != nil {
+			keys[k.Kid] = key
+		}
+	}
+
+	if len(keys) == 0 {
+		return nil, errors.New("JWKS has no RSA or P-256 signing keys")
+	}
+	return keys, nil
+}
+
+// publicKey decodes the key, returning nil for key types the gateway doesn't verify
+func (k jwk) publicKey() (any, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, nil
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !elliptic.P256().IsOnCurve(x, y) {
+			return nil, errors.New("point is not on the P-256 curve")
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, nil
+	}
+	return nil, nil
+}
+
+func decodeBigInt(value string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
@@ -0,0 +1,185 @@
+package auth
+
+import (
+	"cmp"
+	"context"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// JWTConfig configures local verification of access tokens. At least one of Secret, JWKSURL or JWKSFile
+// must be set.
+type JWTConfig struct {
+	// Secret verifies HS256 tokens.
+	Secret string
+	// JWKSURL or JWKSFile hold the public keys that verify RS256 and ES256 tokens, selected by the token's kid.
+	JWKSURL  string
+	JWKSFile string
+	// JWKSRefreshInterval is how often the key set is reloaded, so rotated keys are picked up.
+	JWKSRefreshInterval time.Duration
+	// Issuer, when set, must match the iss claim. Audience must be contained in the aud claim.
+	Issuer   string
+	Audience string
+	// UserIDClaim names the claim holding the numeric user ID, "sub" by default.
+	UserIDClaim string
+	// RolesClaim and ScopesClaim name the claims holding the roles and scopes, "roles" and "scope" by
+	// default. Each may be a JSON array or a space-separated string.
+	RolesClaim  string
+	ScopesClaim string
+	// Leeway allows for clock skew when checking exp, nbf and iat.
+	Leeway time.Duration
+}
+
+// JWT verifies access tokens in the gateway, without calling the user service.
+type JWT struct {
+	secret      []byte
+	keys        *keySet
+	parser      *jwt.Parser
+	userIDClaim string
+	rolesClaim  string
+	scopesClaim string
+}
+
+// NewJWT returns a local authenticator. The JWKS, if configured, is loaded before it returns.
+func NewJWT(ctx context.Context, cfg JWTConfig) (*JWT, error) {
+	if cfg.Secret == "" && cfg.JWKSURL == "" && cfg.JWKSFile == "" {
+		return nil, errors.New("a secret, JWKS URL or JWKS file is required")
+	}
+
+	var methods []string
+	if cfg.Secret != "" {
+		methods = append(methods, jwt.SigningMethodHS256.Alg())
+	}
+
+	v := &JWT{
+		secret:      []byte(cfg.Secret),
+		userIDClaim: cmp.Or(cfg.UserIDClaim, "sub"),
+		rolesClaim:  cmp.Or(cfg.RolesClaim, "roles"),
+		scopesClaim: cmp.Or(cfg.ScopesClaim, "scope"),
+	}
+
+	if cfg.JWKSURL != "" || cfg.JWKSFile != "" {
+		v.keys = newKeySet(cfg.JWKSURL, cfg.JWKSFile, cfg.JWKSRefreshInterval)
+		if err := v.keys.load(ctx); err != nil {
+			return nil, fmt.Errorf("loading JWKS: %w", err)
+		}
+		methods = append(methods, jwt.SigningMethodRS256.Alg(), jwt.SigningMethodES256.Alg())
+	}
+
+	options := []jwt.ParserOption{
+		jwt.WithValidMethods(methods),
+		jwt.WithAudience(cfg.Audience),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(cfg.Leeway),
+		jwt.WithJSONNumber(),
+	}
+	if cfg.Issuer != "" {
+		options = append(options, jwt.WithIssuer(cfg.Issuer))
+	}
+	v.parser = jwt.NewParser(options...)
+
+	return v, nil
+}
+
+func (v *JWT) Authenticate(ctx context.Context, token string) (Identity, error) {
+	claims := jwt.MapClaims{}
+	_, err := v.parser.ParseWithClaims(token, claims, func(t *jwt.Token) (any, error) {
+		return v.key(ctx, t)
+	})
+	if err != nil {
+		// Keys that can't be fetched are an outage rather than a bad token
+		var unavailable *keysUnavailableError
+		if errors.As(err, &unavailable) {
+			return Identity{}, unavailable
+		}
+		return Identity{}, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+
+	userID, err := numericClaim(claims, v.userIDClaim)
+	if err != nil {
+		return Identity{}, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	roles, err := listClaim(claims, v.rolesClaim)
+	if err != nil {
+		return Identity{}, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	scopes, err := listClaim(claims, v.scopesClaim)
+	if err != nil {
+		return Identity{}, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	return Identity{UserID: userID, Roles: roles, Scopes: scopes}, nil
+}
+
+// key returns the key verifying t. The parser has already checked that its algorithm is one of the
+// configured ones, so HMAC tokens are only checked against the secret and RSA or EC tokens against the JWKS.
+func (v *JWT) key(ctx context.Context, t *jwt.Token) (any, error) {
+	switch t.Method.(type) {
+	case *jwt.SigningMethodHMAC:
+		return v.secret, nil
+	case *jwt.SigningMethodRSA, *jwt.SigningMethodECDSA:
+		kid, _ := t.Header["kid"].(string)
+		key, err := v.keys.get(ctx, kid)
+		if err != nil {
+			return nil, err
+		}
+
+		switch key.(type) {
+		case *rsa.PublicKey:
+			if _, ok := t.Method.(*jwt.SigningMethodRSA); ok {
+				return key, nil
+			}
+		case *ecdsa.PublicKey:
+			if _, ok := t.Method.(*jwt.SigningMethodECDSA); ok {
+				return key, nil
+			}
+		}
+		return nil, fmt.Errorf("key %q does not match algorithm %s", kid, t.Method.Alg())
+	}
+	return nil, fmt.Errorf("unexpected signing method %s", t.Method.Alg())
+}
+
+// numericClaim reads a user ID claim, which may be a JSON number or a numeric string such as sub
+func numericClaim(claims jwt.MapClaims, name string) (uint64, error) {
+	switch value := claims[name].(type) {
+	case json.Number:
+		return strconv.ParseUint(value.String(), 10, 64)
+	case string:
+		return strconv.ParseUint(value, 10, 64)
+	case nil:
+		return 0, fmt.Errorf("missing %s claim", name)
+	default:
+		return 0, fmt.Errorf("%s claim is not a user ID", name)
+	}
+}
+
+// listClaim reads a roles or scopes claim, which may be an array of strings or a space-separated string
+// such as the OAuth scope claim. A missing claim is an empty list.
+func listClaim(claims jwt.MapClaims, name string) ([]string, error) {
+	switch value := claims[name].(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return strings.Fields(value), nil
+	case []any:
+		items := make([]string, 0, len(value))
+		for _, item := range value {
+			s, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s claim must only hold strings", name)
+			}
+			items = append(items, s)
+		}
+		return items, nil
+	default:
+		return nil, fmt.Errorf("%s claim is not a list", name)
+	}
+}
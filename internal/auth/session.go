@@ -0,0 +1,201 @@
+package auth
+
+import (
+	"cmp"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// Ways refresh tokens are handed to clients.
+const (
+	// TransportCookie keeps the refresh token in an HttpOnly cookie, out of reach of scripts and JSON bodies.
+	TransportCookie = "cookie"
+	// TransportBody returns the refresh token in JSON bodies, for clients that can't keep cookies.
+	TransportBody = "body"
+)
+
+// RefreshCookieName is the cookie holding the refresh token in cookie mode.
+const RefreshCookieName = "Authorization"
+
+// reuseGrace is how long a rotated token can be presented again without revoking its family, so two tabs
+// refreshing at the same moment don't log the user out
+const reuseGrace = 5 * time.Second
+
+// ErrRefreshTokenRotated is returned when a refresh token was replaced moments ago, typically by a concurrent
+// refresh from another tab. The client already holds, or is about to receive, its successor.
+var ErrRefreshTokenRotated = errors.New("refresh token was just rotated")
+
+// ErrRefreshTokenReused is returned when a refresh token that was already rotated is presented again.
+// Either the client or an attacker holds a stolen copy, so the whole family has been revoked.
+var ErrRefreshTokenReused = errors.New("refresh token reused")
+
+// SessionConfig configures refresh sessions.
+type SessionConfig struct {
+	// Transport is TransportCookie or TransportBody.
+	Transport string
+	// Lifetime is how long a session lasts without being refreshed, and the max age of the cookie.
+	Lifetime time.Duration
+	// CookieDomain and CookieSameSite ("lax", "strict" or "none") set the attributes of the cookie.
+	CookieDomain   string
+	CookieSameSite string
+}
+
+// Session is what the gateway knows about a refresh token.
+type Session struct {
+	UserID uint64
+	// BackendToken is the refresh token issued by the user service, which never leaves the gateway.
+	BackendToken string
+}
+
+// Sessions rotates refresh tokens. The user service's refresh tokens don't change when used, so the gateway
+// hands out opaque tokens of its own instead, replacing them on every refresh. All tokens descending from
+// one login form a family; presenting a token that was already replaced revokes the family.
+//
+// Only hashes of the tokens are stored. Sessions survive restarts and are shared between instances when
+// the store is.
+type Sessions struct {
+	cfg   SessionConfig
+	store SessionStore
+}
+
+// NewSessions returns sessions kept in store.
+func NewSessions(cfg SessionConfig, store SessionStore) *Sessions {
+	return &Sessions{cfg: cfg, store: store}
+}
+
+// CookieMode reports whether refresh tokens are kept in a cookie rather than in JSON bodies.
+func (s *Sessions) CookieMode() bool {
+	return s.cfg.Transport != TransportBody
+}
+
+// Start begins a session for a login and returns its first refresh token.
+func (s *Sessions) Start(ctx context.Context, userID uint64, backendToken string) (string, error) {
+	token, err := newRefreshToken()
+	if err != nil {
+		return "", err
+	}
+	familyID, err := newRefreshToken()
+	if err != nil {
+		return "", err
+	}
+
+	session := Session{UserID: userID, BackendToken: backendToken}
+	if err := s.store.Create(ctx, familyID, session, hashToken(token), s.cfg.Lifetime); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// Lookup returns the session of a current refresh token. It returns ErrInvalidToken for unknown or expired
+// tokens, ErrRefreshTokenRotated for tokens rotated moments ago, and ErrRefreshTokenReused after revoking
+// the family of a token that was rotated earlier.
+func (s *Sessions) Lookup(ctx context.Context, token string) (Session, error) {
+	state, err := s.current(ctx, hashToken(token))
+	if err != nil {
+		return Session{}, err
+	}
+	return state.Session, nil
+}
+
+// Rotate replaces a current refresh token with a new one, which it returns. It fails like Lookup when the
+// token was rotated or revoked in the meantime.
+func (s *Sessions) Rotate(ctx context.Context, token string) (string, error) {
+	next, err := newRefreshToken()
+	if err != nil {
+		return "", err
+	}
+
+	hash := hashToken(token)
+	state, err := s.current(ctx, hash)
+	if err != nil {
+		return "", err
+	}
+
+	rotated, err := s.store.Rotate(ctx, state.FamilyID, hash, hashToken(next), s.cfg.Lifetime)
+	if err != nil {
+		return "", err
+	}
+	if !rotated {
+		// A concurrent refresh rotated the token between the lookup and here
+		_, err := s.current(ctx, hash)
+		return "", cmp.Or(err, ErrRefreshTokenRotated)
+	}
+	return next, nil
+}
+
+// InvalidateUser revokes every session of the user, e.g. when they log out or change password.
+func (s *Sessions) InvalidateUser(ctx context.Context, userID uint64) error {
+	return s.store.RevokeUser(ctx, userID)
+}
+
+// current returns the state of a token that is its family's current one
+func (s *Sessions) current(ctx context.Context, hash string) (TokenState, error) {
+	state, ok, err := s.store.Find(ctx, hash)
+	if err != nil {
+		return TokenState{}, err
+	}
+	if !ok {
+		return TokenState{}, ErrInvalidToken
+	}
+	if state.RetiredAt.IsZero() {
+		return state, nil
+	}
+
+	if time.Since(state.RetiredAt) < reuseGrace {
+		return TokenState{}, ErrRefreshTokenRotated
+	}
+	if err := s.store.Revoke(ctx, state.FamilyID); err != nil {
+		return TokenState{}, err
+	}
+	return TokenState{}, ErrRefreshTokenReused
+}
+
+// Cookie returns the cookie carrying a refresh token.
+func (s *Sessions) Cookie(token string) *http.Cookie {
+	return &http.Cookie{
+		Name:     RefreshCookieName,
+		Value:    token,
+		Path:     "/",
+		Domain:   s.cfg.CookieDomain,
+		MaxAge:   int(s.cfg.Lifetime.Seconds()),
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: sameSite(s.cfg.CookieSameSite),
+	}
+}
+
+// ClearCookie returns a cookie that deletes the refresh token cookie.
+func (s *Sessions) ClearCookie() *http.Cookie {
+	cookie := s.Cookie("")
+	cookie.MaxAge = -1
+	return cookie
+}
+
+func newRefreshToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func sameSite(value string) http.SameSite {
+	switch value {
+	case "strict":
+		return http.SameSiteStrictMode
+	case "none":
+		return http.SameSiteNoneMode
+	}
+	return http.SameSiteLaxMode
+}
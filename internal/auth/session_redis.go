@@ -0,0 +1,144 @@
+package auth
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// rotateScript swaps a family's current token only if it is still the one presented, so concurrent
+// refreshes can't both win, and keeps the family's keys alive for another lifetime
+var rotateScript = redis.NewScript(`
+if redis.call("HGET", KEYS[1], "current") ~= ARGV[1] then
+	return 0
+end
+redis.call("HSET", KEYS[1], "current", ARGV[2])
+redis.call("PEXPIRE", KEYS[1], ARGV[5])
+redis.call("HSET", KEYS[2], "family", ARGV[3], "retired_at", ARGV[4])
+redis.call("PEXPIRE", KEYS[2], ARGV[5])
+redis.call("HSET", KEYS[3], "family", ARGV[3])
+redis.call("PEXPIRE", KEYS[3], ARGV[5])
+redis.call("PEXPIRE", ARGV[6] .. redis.call("HGET", KEYS[1], "user_id"), ARGV[5])
+return 1
+`)
+
+// RedisSessionStore keeps the sessions in Redis, or any server speaking its protocol, so they survive
+// restarts and every gateway instance of a cluster shares them. Families are hashes holding the user
+// service's refresh token; token hashes point to their family, and each user has a set of families.
+type RedisSessionStore struct {
+	client redis.UniversalClient
+	prefix string
+}
+
+// NewRedisSessionStore returns a store keeping its keys under prefix, e.g. "eco-taxi:session:".
+func NewRedisSessionStore(client redis.UniversalClient, prefix string) *RedisSessionStore {
+	return &RedisSessionStore{client: client, prefix: prefix}
+}
+
+func (s *RedisSessionStore) familyKey(familyID string) string { return s.prefix + "family:" + familyID }
+func (s *RedisSessionStore) tokenKey(hash string) string      { return s.prefix + "token:" + hash }
+func (s *RedisSessionStore) userKey(userID uint64) string {
+	return s.prefix + "user:" + strconv.FormatUint(userID, 10)
+}
+
+func (s *RedisSessionStore) Create(ctx context.Context, familyID string, session Session, hash string, ttl time.Duration) error {
+	familyKey, tokenKey, userKey := s.familyKey(familyID), s.tokenKey(hash), s.userKey(session.UserID)
+
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, familyKey, "user_id", session.UserID, "backend_token", session.BackendToken, "current", hash)
+		pipe.PExpire(ctx, familyKey, ttl)
+		pipe.HSet(ctx, tokenKey, "family", familyID)
+		pipe.PExpire(ctx, tokenKey, ttl)
+		pipe.SAdd(ctx, userKey, familyID)
+		pipe.PExpire(ctx, userKey, ttl)
+		return nil
+	})
+	return err
+}
+
+func (s *RedisSessionStore) Find(ctx context.Context, hash string) (TokenState, bool, error) {
+	token, err := s.client.HGetAll(ctx, s.tokenKey(hash)).Result()
+	if err != nil || token["family"] == "" {
+		return TokenState{}, false, err
+	}
+
+	// Retired tokens outlive a revoked family, which is gone
+	family, err := s.client.HGetAll(ctx, s.familyKey(token["family"])).Result()
+	if err != nil || len(family) == 0 {
+		return TokenState{}, false, err
+	}
+
+	userID, err := strconv.ParseUint(family["user_id"], 10, 64)
+	if err != nil {
+		return TokenState{}, false, err
+	}
+	state := TokenState{
+		FamilyID: token["family"],
+		Session:  Session{UserID: userID, BackendToken: family["backend_token"]},
+	}
+
+	if family["current"] != hash {
+		// The token may have been rotated between the two reads; the rotation set its retired_at together
+		// with the family's current token, so reading it again after the family sees it
+		retiredAt := token["retired_at"]
+		if retiredAt == "" {
+			if retiredAt, err = s.client.HGet(ctx, s.tokenKey(hash), "retired_at").Result(); err != nil {
+				return TokenState{}, false, err
+			}
+		}
+
+		at, err := strconv.ParseInt(retiredAt, 10, 64)
+		if err != nil {
+			return TokenState{}, false, err
+		}
+		state.RetiredAt = time.UnixMilli(at)
+	}
+	return state, true, nil
+}
+
+func (s *RedisSessionStore) Rotate(ctx context.Context, familyID, hash, next string, ttl time.Duration) (bool, error) {
+	keys := []string{s.familyKey(familyID), s.tokenKey(hash), s.tokenKey(next)}
+	rotated, err := rotateScript.Run(ctx, s.client, keys,
+		hash, next, familyID, time.Now().UnixMilli(), ttl.Milliseconds(), s.prefix+"user:").Int()
+	if err != nil {
+		return false, err
+	}
+	return rotated == 1, nil
+}
+
+func (s *RedisSessionStore) Revoke(ctx context.Context, familyID string) error {
+	familyKey := s.familyKey(familyID)
+
+	userID, err := s.client.HGet(ctx, familyKey, "user_id").Uint64()
+	if err == redis.Nil {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// The family's tokens expire on their own; without the family they are no longer found
+	_, err = s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, familyKey)
+		pipe.SRem(ctx, s.userKey(userID), familyID)
+		return nil
+	})
+	return err
+}
+
+func (s *RedisSessionStore) RevokeUser(ctx context.Context, userID uint64) error {
+	userKey := s.userKey(userID)
+
+	familyIDs, err := s.client.SMembers(ctx, userKey).Result()
+	if err != nil {
+		return err
+	}
+
+	keys := []string{userKey}
+	for _, familyID := range familyIDs {
+		keys = append(keys, s.familyKey(familyID))
+	}
+	return s.client.Del(ctx, keys...).Err()
+}
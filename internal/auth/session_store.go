@@ -0,0 +1,167 @@
+package auth
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// maxRetiredTokens bounds how many rotated tokens of a family the memory store remembers for reuse detection
+const maxRetiredTokens = 16
+
+// TokenState is what a SessionStore knows about a refresh token hash.
+type TokenState struct {
+	FamilyID string
+	Session  Session
+	// RetiredAt is when the token was rotated, zero while it is its family's current token.
+	RetiredAt time.Time
+}
+
+// SessionStore keeps refresh token families by token hash. Implementations must be safe for concurrent use,
+// and shared between gateway instances for a refresh to succeed on another instance than the login.
+type SessionStore interface {
+	// Create saves a new family whose current token has the given hash. The family expires after ttl.
+	Create(ctx context.Context, familyID string, session Session, hash string, ttl time.Duration) error
+	// Find returns the state of a token of a family that hasn't expired or been revoked.
+	Find(ctx context.Context, hash string) (TokenState, bool, error)
+	// Rotate makes next the family's current token and retires hash, extending the family by ttl. It does
+	// nothing and returns false unless hash is still the current token, so only one of concurrent
+	// rotations wins.
+	Rotate(ctx context.Context, familyID, hash, next string, ttl time.Duration) (bool, error)
+	// Revoke removes a family with all its tokens.
+	Revoke(ctx context.Context, familyID string) error
+	// RevokeUser removes every family of the user.
+	RevokeUser(ctx context.Context, userID uint64) error
+}
+
+// MemorySessionStore keeps the sessions in memory, so they are lost when the gateway restarts and aren't
+// shared between instances.
+type MemorySessionStore struct {
+	mu       sync.Mutex
+	families map[string]*memoryFamily
+	tokens   map[string]string // family IDs by token hash, including retired tokens
+	sweptAt  time.Time
+}
+
+type memoryFamily struct {
+	session Session
+	current string
+	retired []retiredToken // oldest first
+	expires time.Time
+}
+
+type retiredToken struct {
+	hash string
+	at   time.Time
+}
+
+// NewMemorySessionStore returns an empty in-memory store.
+func NewMemorySessionStore() *MemorySessionStore {
+	return &MemorySessionStore{families: map[string]*memoryFamily{}, tokens: map[string]string{}}
+}
+
+func (s *MemorySessionStore) Create(_ context.Context, familyID string, session Session, hash string, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.sweep(now)
+
+	s.families[familyID] = &memoryFamily{session: session, current: hash, expires: now.Add(ttl)}
+	s.tokens[hash] = familyID
+	return nil
+}
+
+func (s *MemorySessionStore) Find(_ context.Context, hash string) (TokenState, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	familyID, ok := s.tokens[hash]
+	if !ok {
+		return TokenState{}, false, nil
+	}
+	f, ok := s.families[familyID]
+	if !ok || time.Now().After(f.expires) {
+		return TokenState{}, false, nil
+	}
+
+	state := TokenState{FamilyID: familyID, Session: f.session}
+	if f.current != hash {
+		for _, retired := range f.retired {
+			if retired.hash == hash {
+				state.RetiredAt = retired.at
+			}
+		}
+	}
+	return state, true, nil
+}
+
+func (s *MemorySessionStore) Rotate(_ context.Context, familyID, hash, next string, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, ok := s.families[familyID]
+	if !ok || f.current != hash {
+		return false, nil
+	}
+
+	now := time.Now()
+	f.retired = append(f.retired, retiredToken{hash: hash, at: now})
+	if len(f.retired) > maxRetiredTokens {
+		delete(s.tokens, f.retired[0].hash)
+		f.retired = f.retired[1:]
+	}
+
+	f.current, f.expires = next, now.Add(ttl)
+	s.tokens[next] = familyID
+	return true, nil
+}
+
+func (s *MemorySessionStore) Revoke(_ context.Context, familyID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.revoke(familyID)
+	return nil
+}
+
+func (s *MemorySessionStore) RevokeUser(_ context.Context, userID uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for familyID, f := range s.families {
+		if f.session.UserID == userID {
+			s.revoke(familyID)
+		}
+	}
+	return nil
+}
+
+// revoke must be called with s.mu held
+func (s *MemorySessionStore) revoke(familyID string) {
+	f, ok := s.families[familyID]
+	if !ok {
+		return
+	}
+
+	delete(s.families, familyID)
+	delete(s.tokens, f.current)
+	for _, retired := range f.retired {
+		delete(s.tokens, retired.hash)
+	}
+}
+
+// sweep revokes the families past their lifetime, which Find already refuses, so that sessions never
+// refreshed or logged out don't pile up. Create calls it under s.mu; it scans at most once a minute.
+func (s *MemorySessionStore) sweep(now time.Time) {
+	if now.Sub(s.sweptAt) < time.Minute {
+		return
+	}
+	s.sweptAt = now
+
+	for familyID, f := range s.families {
+		if now.After(f.expires) {
+			s.revoke(familyID)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/haiyen11231/eco-taxi-api-gateway/internal/redistest"
+)
+
+// sessionStores returns an empty memory store and an empty Redis store, so each session test runs against both
+func sessionStores(t *testing.T) map[string]SessionStore {
+	return map[string]SessionStore{
+		"memory": NewMemorySessionStore(),
+		"redis":  NewRedisSessionStore(redistest.NewClient(t), "test:session:"),
+	}
+}
+
+func testSessions(store SessionStore) *Sessions {
+	return NewSessions(SessionConfig{Transport: TransportCookie, Lifetime: time.Hour}, store)
+}
+
+func TestSessionsRotate(t *testing.T) {
+	ctx := context.Background()
+	for name, store := range sessionStores(t) {
+		t.Run(name, func(t *testing.T) {
+			s := testSessions(store)
+
+			first, err := s.Start(ctx, 42, "backend-token")
+			if err != nil {
+				t.Fatal(err)
+			}
+			session, err := s.Lookup(ctx, first)
+			if err != nil || session != (Session{UserID: 42, BackendToken: "backend-token"}) {
+				t.Fatalf("Lookup() = %+v, %v", session, err)
+			}
+
+			second, err := s.Rotate(ctx, first)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if second == first {
+				t.Fatal("Rotate() returned the same token")
+			}
+			if _, err := s.Lookup(ctx, second); err != nil {
+				t.Errorf("Lookup(rotated token) error = %v", err)
+			}
+
+			// Within the grace period the old token is refused without revoking the family
+			if _, err := s.Lookup(ctx, first); !errors.Is(err, ErrRefreshTokenRotated) {
+				t.Errorf("Lookup(old token) error = %v, want %v", err, ErrRefreshTokenRotated)
+			}
+			if _, err := s.Lookup(ctx, second); err != nil {
+				t.Errorf("Lookup(rotated token) after grace refusal error = %v", err)
+			}
+		})
+	}
+}
+
+func TestSessionsReuseRevokesFamily(t *testing.T) {
+	ctx := context.Background()
+	for name, store := range sessionStores(t) {
+		t.Run(name, func(t *testing.T) {
+			s := testSessions(store)
+
+			first, _ := s.Start(ctx, 42, "backend-token")
+			second, err := s.Rotate(ctx, first)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Rotated long enough ago that presenting it again means it was copied
+			state, _, _ := store.Find(ctx, hashToken(first))
+			backdateRetirement(t, store, state.FamilyID, hashToken(first))
+
+			if _, err := s.Lookup(ctx, first); !errors.Is(err, ErrRefreshTokenReused) {
+				t.Fatalf("Lookup(reused token) error = %v, want %v", err, ErrRefreshTokenReused)
+			}
+			if _, err := s.Lookup(ctx, second); !errors.Is(err, ErrInvalidToken) {
+				t.Errorf("Lookup(current token of revoked family) error = %v, want %v", err, ErrInvalidToken)
+			}
+		})
+	}
+}
+
+func TestSessionsConcurrentRotateHasOneWinner(t *testing.T) {
+	ctx := context.Background()
+	for name, store := range sessionStores(t) {
+		t.Run(name, func(t *testing.T) {
+			s := testSessions(store)
+			token, _ := s.Start(ctx, 42, "backend-token")
+
+			var wg sync.WaitGroup
+			errs := make(chan error, 10)
+			for i := 0; i < 10; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					_, err := s.Rotate(ctx, token)
+					errs <- err
+				}()
+			}
+			wg.Wait()
+			close(errs)
+
+			won := 0
+			for err := range errs {
+				switch {
+				case err == nil:
+					won++
+				case !errors.Is(err, ErrRefreshTokenRotated):
+					t.Errorf("Rotate() error = %v, want nil or %v", err, ErrRefreshTokenRotated)
+				}
+			}
+			if won != 1 {
+				t.Errorf("%d concurrent rotations succeeded, want 1", won)
+			}
+		})
+	}
+}
+
+func TestSessionsInvalidateUser(t *testing.T) {
+	ctx := context.Background()
+	for name, store := range sessionStores(t) {
+		t.Run(name, func(t *testing.T) {
+			s := testSessions(store)
+
+			phone, _ := s.Start(ctx, 42, "a")
+			laptop, _ := s.Start(ctx, 42, "b")
+			other, _ := s.Start(ctx, 7, "c")
+			laptop, _ = s.Rotate(ctx, laptop)
+
+			if err := s.InvalidateUser(ctx, 42); err != nil {
+				t.Fatal(err)
+			}
+			for _, token := range []string{phone, laptop} {
+				if _, err := s.Lookup(ctx, token); !errors.Is(err, ErrInvalidToken) {
+					t.Errorf("Lookup() after InvalidateUser error = %v, want %v", err, ErrInvalidToken)
+				}
+			}
+			if _, err := s.Lookup(ctx, other); err != nil {
+				t.Errorf("Lookup(other user's token) error = %v", err)
+			}
+		})
+	}
+}
+
+func TestSessionsUnknownToken(t *testing.T) {
+	ctx := context.Background()
+	for name, store := range sessionStores(t) {
+		t.Run(name, func(t *testing.T) {
+			s := testSessions(store)
+			if _, err := s.Lookup(ctx, "unknown"); !errors.Is(err, ErrInvalidToken) {
+				t.Errorf("Lookup() error = %v, want %v", err, ErrInvalidToken)
+			}
+			if _, err := s.Rotate(ctx, "unknown"); !errors.Is(err, ErrInvalidToken) {
+				t.Errorf("Rotate() error = %v, want %v", err, ErrInvalidToken)
+			}
+		})
+	}
+}
+
+// backdateRetirement moves a retired token's rotation past the reuse grace period
+func backdateRetirement(t *testing.T, store SessionStore, familyID, hash string) {
+	t.Helper()
+
+	at := time.Now().Add(-2 * reuseGrace)
+	switch store := store.(type) {
+	case *MemorySessionStore:
+		store.mu.Lock()
+		defer store.mu.Unlock()
+		for i := range store.families[familyID].retired {
+			if store.families[familyID].retired[i].hash == hash {
+				store.families[familyID].retired[i].at = at
+			}
+		}
+	case *RedisSessionStore:
+		if err := store.client.HSet(context.Background(), store.tokenKey(hash), "retired_at", at.UnixMilli()).Err(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
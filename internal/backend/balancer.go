@@ -0,0 +1,66 @@
+package backend
+
+import (
+	"fmt"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/balancer/leastrequest"
+	"google.golang.org/grpc/balancer/roundrobin"
+	_ "google.golang.org/grpc/health" // Enables client-side health checking so NOT_SERVING replicas leave the rotation
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/resolver/manual"
+)
+
+// Load balancing policies accepted in Config.LoadBalancing.
+const (
+	RoundRobin   = "round_robin"
+	LeastRequest = "least_request"
+)
+
+// staticScheme is the resolver scheme used for comma-separated address lists.
+const staticScheme = "static"
+
+// resolveTarget turns a configured host setting into a dial target.
+// A single address or a URI such as "dns:///trip-service:5003" is passed to gRPC as is,
+// while a comma-separated list of addresses is served by a manual resolver installed on the connection.
+func resolveTarget(name, hosts string) (string, []grpc.DialOption, error) {
+	parts := strings.Split(hosts, ",")
+	if len(parts) == 1 {
+		return strings.TrimSpace(hosts), nil, nil
+	}
+
+	addrs := make([]resolver.Address, 0, len(parts))
+	for _, p := range parts {
+		addr := strings.TrimSpace(p)
+		if addr == "" {
+			continue
+		}
+		if strings.Contains(addr, "://") {
+			return "", nil, fmt.Errorf("%s service: address lists must contain plain host:port entries, got %q", name, addr)
+		}
+		addrs = append(addrs, resolver.Address{Addr: addr})
+	}
+
+	r := manual.NewBuilderWithScheme(staticScheme)
+	r.InitialState(resolver.State{Addresses: addrs})
+
+	return fmt.Sprintf("%s:///%s", staticScheme, name), []grpc.DialOption{grpc.WithResolvers(r)}, nil
+}
+
+// serviceConfig builds the default service config for a backend connection.
+// Subchannels are health checked through grpc.health.v1 so replicas that fail or report NOT_SERVING are
+// ejected from the picker until they recover; replicas whose connection breaks are skipped the same way.
+func serviceConfig(policy string) (string, error) {
+	var lb string
+	switch policy {
+	case "", RoundRobin:
+		lb = fmt.Sprintf(`{"%s":{}}`, roundrobin.Name)
+	case LeastRequest:
+		lb = fmt.Sprintf(`{"%s":{"choiceCount":2}}`, leastrequest.Name)
+	default:
+		return "", fmt.Errorf("unknown load balancing policy %q", policy)
+	}
+
+	return fmt.Sprintf(`{"loadBalancingConfig":[%s],"healthCheckConfig":{"serviceName":""}}`, lb), nil
+}
@@ -0,0 +1,174 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// Circuit breaker states.
+const (
+	CircuitClosed   = "closed"
+	CircuitOpen     = "open"
+	CircuitHalfOpen = "half-open"
+)
+
+// BreakerConfig tunes the circuit breaker that guards each backend connection.
+// Zero values fall back to the defaults below.
+type BreakerConfig struct {
+	// FailureThreshold is the number of consecutive failed calls that opens the circuit.
+	FailureThreshold int
+	// CoolDown is how long the circuit stays open before a probe call is let through.
+	CoolDown time.Duration
+	// HalfOpenMaxCalls is the number of concurrent probe calls allowed while half-open.
+	HalfOpenMaxCalls int
+}
+
+const (
+	defaultFailureThreshold = 5
+	defaultCoolDown         = 30 * time.Second
+	defaultHalfOpenMaxCalls = 1
+)
+
+func (cfg BreakerConfig) withDefaults() BreakerConfig {
+	if cfg.FailureThreshold <= 0 {
+		cfg.FailureThreshold = defaultFailureThreshold
+	}
+	if cfg.CoolDown <= 0 {
+		cfg.CoolDown = defaultCoolDown
+	}
+	if cfg.HalfOpenMaxCalls <= 0 {
+		cfg.HalfOpenMaxCalls = defaultHalfOpenMaxCalls
+	}
+	return cfg
+}
+
+// CircuitOpenError is returned without calling the backend while its circuit is open.
+// It converts to an Unavailable gRPC status and tells the caller when to try again.
+type CircuitOpenError struct {
+	Service    string
+	retryAfter time.Duration
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("%s service is unavailable: circuit breaker is open", e.Service)
+}
+
+// RetryAfter is the remaining cool-down before the breaker lets a probe call through.
+func (e *CircuitOpenError) RetryAfter() time.Duration {
+	return e.retryAfter
+}
+
+// GRPCStatus lets status.FromError and status.Code treat the error as Unavailable.
+func (e *CircuitOpenError) GRPCStatus() *status.Status {
+	return status.New(codes.Unavailable, e.Error())
+}
+
+type breaker struct {
+	service string
+	cfg     BreakerConfig
+
+	mu       sync.Mutex
+	state    string
+	failures int
+	openedAt time.Time
+	probes   int
+}
+
+func newBreaker(service string, cfg BreakerConfig) *breaker {
+	return &breaker{service: service, cfg: cfg.withDefaults(), state: CircuitClosed}
+}
+
+// State returns the current breaker state, moving an expired open circuit to half-open.
+func (b *breaker) State() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == CircuitOpen && time.Since(b.openedAt) >= b.cfg.CoolDown {
+		b.state, b.probes = CircuitHalfOpen, 0
+	}
+	return b.state
+}
+
+// allow reports whether a call may go to the backend, and whether it goes as a half-open probe. If it may
+// not, it returns how long the caller should wait.
+func (b *breaker) allow() (ok, probe bool, retryAfter time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case CircuitOpen:
+		remaining := b.cfg.CoolDown - time.Since(b.openedAt)
+		if remaining > 0 {
+			return false, false, remaining
+		}
+		b.state, b.probes = CircuitHalfOpen, 0
+		fallthrough
+	case CircuitHalfOpen:
+		if b.probes >= b.cfg.HalfOpenMaxCalls {
+			return false, false, time.Second
+		}
+		b.probes++
+		return true, true, 0
+	}
+	return true, false, 0
+}
+
+// record updates the breaker with the outcome of a call made with ctx that was allowed through, as a probe
+// or not. Calls let through before the circuit opened finish without freeing a probe's place.
+func (b *breaker) record(ctx context.Context, probe bool, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if probe && b.state == CircuitHalfOpen && b.probes > 0 {
+		b.probes--
+	}
+
+	// A call abandoned by the client, or cut short by the client's own deadline, says nothing about the
+	// backend; only the method timeout expiring does
+	if status.Code(err) == codes.Canceled || callerContext(ctx).Err() != nil {
+		return
+	}
+
+	if !isBackendFailure(err) {
+		b.state, b.failures = CircuitClosed, 0
+		return
+	}
+
+	b.failures++
+	if b.state == CircuitHalfOpen || b.failures >= b.cfg.FailureThreshold {
+		b.state, b.openedAt, b.failures = CircuitOpen, time.Now(), 0
+	}
+}
+
+// isBackendFailure separates an unhealthy backend from ordinary business errors, which must not trip the circuit.
+func isBackendFailure(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted:
+		return true
+	}
+	return false
+}
+
+// unaryInterceptor fails calls fast while the circuit is open. Health checks bypass the breaker
+// so readiness keeps reporting the real state of the backend.
+func (b *breaker) unaryInterceptor(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	if method == healthpb.Health_Check_FullMethodName {
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+
+	ok, probe, retryAfter := b.allow()
+	if !ok {
+		return &CircuitOpenError{Service: b.service, retryAfter: retryAfter}
+	}
+
+	err := invoker(ctx, method, req, reply, cc, opts...)
+	b.record(ctx, probe, err)
+	return err
+}
@@ -0,0 +1,139 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/haiyen11231/eco-taxi-api-gateway/internal/grpc/pb"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const testMethod = pb.TripService_SearchTripPreview_FullMethodName
+
+// callThrough makes a call through the method timeout and breaker interceptors, the way the registry chains
+// them, to a backend answering with backendErr or hanging until the call's context ends
+func callThrough(ctx context.Context, timeouts methodTimeouts, b *breaker, backendErr error) error {
+	backend := func(ctx context.Context, _ string, _, _ any, _ *grpc.ClientConn, _ ...grpc.CallOption) error {
+		if backendErr != nil {
+			return backendErr
+		}
+		<-ctx.Done()
+		return status.FromContextError(ctx.Err()).Err()
+	}
+
+	return timeouts.unaryInterceptor(ctx, testMethod, nil, nil, nil,
+		func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+			return b.unaryInterceptor(ctx, method, req, reply, cc, backend, opts...)
+		})
+}
+
+func TestBreakerOpensAfterConsecutiveFailures(t *testing.T) {
+	b := newBreaker("trip", BreakerConfig{FailureThreshold: 3, CoolDown: time.Minute})
+	unavailable := status.Error(codes.Unavailable, "down")
+
+	for i := 0; i < 3; i++ {
+		if err := callThrough(context.Background(), nil, b, unavailable); status.Code(err) != codes.Unavailable {
+			t.Fatalf("call %d: got %v", i, err)
+		}
+	}
+
+	var open *CircuitOpenError
+	if err := callThrough(context.Background(), nil, b, unavailable); !errors.As(err, &open) {
+		t.Fatalf("circuit not open after the threshold: %v", err)
+	}
+	if b.State() != CircuitOpen {
+		t.Errorf("State() = %s, want %s", b.State(), CircuitOpen)
+	}
+}
+
+func TestBreakerIgnoresBusinessErrors(t *testing.T) {
+	b := newBreaker("trip", BreakerConfig{FailureThreshold: 2})
+
+	for i := 0; i < 5; i++ {
+		callThrough(context.Background(), nil, b, status.Error(codes.NotFound, "no booking"))
+	}
+	if b.State() != CircuitClosed {
+		t.Errorf("State() = %s, want %s", b.State(), CircuitClosed)
+	}
+}
+
+func TestBreakerIgnoresCallerDeadline(t *testing.T) {
+	b := newBreaker("trip", BreakerConfig{FailureThreshold: 2})
+	timeouts := methodTimeouts{testMethod: time.Second}
+
+	// The client's own short deadline expires long before the method timeout
+	for i := 0; i < 5; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+		err := callThrough(ctx, timeouts, b, nil)
+		cancel()
+		if status.Code(err) != codes.DeadlineExceeded {
+			t.Fatalf("call %d: got %v", i, err)
+		}
+	}
+	if b.State() != CircuitClosed {
+		t.Errorf("State() = %s after caller deadlines, want %s", b.State(), CircuitClosed)
+	}
+}
+
+func TestBreakerCountsMethodTimeout(t *testing.T) {
+	b := newBreaker("trip", BreakerConfig{FailureThreshold: 2, CoolDown: time.Minute})
+	timeouts := methodTimeouts{testMethod: time.Millisecond}
+
+	for i := 0; i < 2; i++ {
+		callThrough(context.Background(), timeouts, b, nil)
+	}
+	if b.State() != CircuitOpen {
+		t.Errorf("State() = %s after method timeouts, want %s", b.State(), CircuitOpen)
+	}
+}
+
+func TestBreakerHalfOpenProbe(t *testing.T) {
+	b := newBreaker("trip", BreakerConfig{FailureThreshold: 1, CoolDown: 10 * time.Millisecond})
+	unavailable := status.Error(codes.Unavailable, "down")
+
+	callThrough(context.Background(), nil, b, unavailable)
+	time.Sleep(20 * time.Millisecond)
+
+	// A failed probe opens the circuit again, a successful one closes it
+	callThrough(context.Background(), nil, b, unavailable)
+	if b.State() != CircuitOpen {
+		t.Fatalf("State() = %s after a failed probe, want %s", b.State(), CircuitOpen)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	if err := callThrough(context.Background(), nil, b, status.Error(codes.NotFound, "")); status.Code(err) != codes.NotFound {
+		t.Fatalf("probe: got %v", err)
+	}
+	if b.State() != CircuitClosed {
+		t.Errorf("State() = %s after a successful probe, want %s", b.State(), CircuitClosed)
+	}
+}
+
+func TestBreakerHalfOpenLimitsProbes(t *testing.T) {
+	b := newBreaker("trip", BreakerConfig{FailureThreshold: 1, CoolDown: 10 * time.Millisecond, HalfOpenMaxCalls: 1})
+
+	// Two calls go through while closed; the first fails and opens the circuit
+	first, _, _ := b.allow()
+	slow, _, _ := b.allow()
+	if !first || !slow {
+		t.Fatal("calls refused while closed")
+	}
+	b.record(context.Background(), false, status.Error(codes.Unavailable, "down"))
+	time.Sleep(20 * time.Millisecond)
+
+	ok, probe, _ := b.allow()
+	if !ok || !probe {
+		t.Fatalf("allow() = %v, %v after the cool-down, want a probe", ok, probe)
+	}
+
+	// The slow call finishing must not free the probe's place
+	b.record(context.Background(), false, status.Error(codes.Canceled, ""))
+	if ok, _, _ := b.allow(); ok {
+		t.Error("a second probe was let through with HalfOpenMaxCalls 1")
+	}
+}
@@ -0,0 +1,55 @@
+package backend
+
+import (
+	"context"
+	"time"
+
+	"github.com/haiyen11231/eco-taxi-api-gateway/internal/grpc/pb"
+
+	"google.golang.org/grpc"
+)
+
+// DefaultMethodTimeouts bounds each RPC, including its retries. A shorter deadline already set on the
+// request context, e.g. by the HTTP route or the client, always wins.
+var DefaultMethodTimeouts = map[string]time.Duration{
+	pb.UserService_SignUp_FullMethodName:                  5 * time.Second,
+	pb.UserService_LogIn_FullMethodName:                   5 * time.Second,
+	pb.UserService_LogOut_FullMethodName:                  5 * time.Second,
+	pb.UserService_ForgotPassword_FullMethodName:          5 * time.Second,
+	pb.UserService_UpdateUser_FullMethodName:              5 * time.Second,
+	pb.UserService_GetUser_FullMethodName:                 5 * time.Second,
+	pb.UserService_RefreshToken_FullMethodName:            5 * time.Second,
+	pb.UserService_ChangePassword_FullMethodName:          time.Second,
+	pb.UserService_UpdateDistanceTravelled_FullMethodName: time.Second,
+	pb.UserService_AuthenticateUser_FullMethodName:        time.Second,
+}
+
+// defaultMethodTimeout applies to methods missing from the timeout table, such as every trip and payment call.
+const defaultMethodTimeout = time.Second
+
+type methodTimeouts map[string]time.Duration
+
+// callerContextKey holds the context a call was made with, before the method timeout was applied
+type callerContextKey struct{}
+
+// callerContext returns the context the call was made with, so its deadline can be told apart from the
+// method timeout.
+func callerContext(ctx context.Context) context.Context {
+	if caller, ok := ctx.Value(callerContextKey{}).(context.Context); ok {
+		return caller
+	}
+	return ctx
+}
+
+func (t methodTimeouts) unaryInterceptor(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	timeout, ok := t[method]
+	if !ok {
+		timeout = defaultMethodTimeout
+	}
+
+	// context.WithTimeout never extends a deadline that is already shorter
+	ctx, cancel := context.WithTimeout(context.WithValue(ctx, callerContextKey{}, ctx), timeout)
+	defer cancel()
+
+	return invoker(ctx, method, req, reply, cc, opts...)
+}
@@ -0,0 +1,90 @@
+package backend
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// Dependency states reported by CheckHealth.
+const (
+	StatusUp      = "up"
+	StatusDown    = "down"
+	StatusUnknown = "unknown"
+)
+
+// DependencyStatus is the result of a grpc.health.v1 check against one backend service.
+type DependencyStatus struct {
+	Name      string  `json:"name"`
+	Status    string  `json:"status"`
+	Critical  bool    `json:"critical"`
+	Circuit   string  `json:"circuit"`
+	LatencyMs float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// ConnectionState is a snapshot of one backend connection and its circuit breaker.
+type ConnectionState struct {
+	Service string `json:"service"`
+	State   string `json:"state"`
+	Circuit string `json:"circuit"`
+}
+
+// ConnectionStates reports the connectivity and circuit breaker state of every backend without making any calls.
+func (r *Registry) ConnectionStates() []ConnectionState {
+	states := make([]ConnectionState, 0, len(r.conns))
+	for _, c := range r.conns {
+		states = append(states, ConnectionState{
+			Service: c.name,
+			State:   c.conn.GetState().String(),
+			Circuit: c.breaker.State(),
+		})
+	}
+	return states
+}
+
+// CheckHealth queries the health service of every backend concurrently.
+// A backend that is reachable but does not implement grpc.health.v1 is reported as unknown rather than down.
+func (r *Registry) CheckHealth(ctx context.Context) []DependencyStatus {
+	results := make([]DependencyStatus, len(r.conns))
+
+	var wg sync.WaitGroup
+	for i, c := range r.conns {
+		wg.Add(1)
+		go func(i int, c *serviceConn) {
+			defer wg.Done()
+			results[i] = c.checkHealth(ctx)
+		}(i, c)
+	}
+	wg.Wait()
+
+	return results
+}
+
+func (c *serviceConn) checkHealth(ctx context.Context) DependencyStatus {
+	result := DependencyStatus{Name: c.name, Critical: c.critical, Circuit: c.breaker.State()}
+
+	start := time.Now()
+	response, err := healthpb.NewHealthClient(c.conn).Check(ctx, &healthpb.HealthCheckRequest{})
+	result.LatencyMs = float64(time.Since(start).Microseconds()) / 1000
+
+	switch {
+	case status.Code(err) == codes.Unimplemented:
+		result.Status = StatusUnknown
+		result.Error = "health service not implemented"
+	case err != nil:
+		result.Status = StatusDown
+		result.Error = status.Convert(err).Message()
+	case response.Status != healthpb.HealthCheckResponse_SERVING:
+		result.Status = StatusDown
+		result.Error = response.Status.String()
+	default:
+		result.Status = StatusUp
+	}
+
+	return result
+}
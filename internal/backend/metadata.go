@@ -0,0 +1,18 @@
+package backend
+
+// Outgoing gRPC metadata keys that identify the HTTP request behind a backend call.
+// Backends can use them to correlate logs and audit callers without new proto fields.
+const (
+	RequestIDHeader = "x-request-id"
+	UserIDHeader    = "x-user-id"
+	// UserRolesHeader and UserScopesHeader carry the caller's roles and scopes, comma-separated. The user
+	// service can also set them on its AuthenticateUser response to grant roles in remote auth mode.
+	UserRolesHeader  = "x-user-roles"
+	UserScopesHeader = "x-user-scopes"
+	// APIKeyIDHeader and APIKeyOwnerHeader identify the partner API key a request was made with.
+	APIKeyIDHeader    = "x-api-key-id"
+	APIKeyOwnerHeader = "x-api-key-owner"
+	ClientIPHeader    = "x-client-ip"
+	// UserAgentHeader carries the HTTP client's user agent; gRPC reserves "user-agent" for its own.
+	UserAgentHeader = "x-client-user-agent"
+)
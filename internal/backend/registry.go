@@ -0,0 +1,153 @@
+package backend
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/haiyen11231/eco-taxi-api-gateway/internal/grpc/pb"
+
+	"google.golang.org/grpc"
+)
+
+// Config holds the dial targets of the backend services.
+// Each host accepts a single address, a comma-separated list of replica addresses or a gRPC target URI such as "dns:///trip-service:5003".
+type Config struct {
+	UserHost    string
+	TripHost    string
+	PaymentHost string
+
+	// UserTLS, TripTLS and PaymentTLS configure transport security per backend; plaintext when disabled.
+	UserTLS    TLSConfig
+	TripTLS    TLSConfig
+	PaymentTLS TLSConfig
+
+	// LoadBalancing selects how calls are spread over replicas: RoundRobin (default) or LeastRequest.
+	LoadBalancing string
+
+	// Breaker configures the circuit breaker wrapped around every backend client.
+	Breaker BreakerConfig
+
+	// MethodTimeouts maps full RPC method names to their timeout; nil selects DefaultMethodTimeouts.
+	MethodTimeouts map[string]time.Duration
+
+	// RetryPolicies maps full RPC method names to their retry policy; nil selects DefaultRetryPolicies.
+	RetryPolicies map[string]RetryPolicy
+
+	// RetryBudget limits the share of retried calls per backend.
+	RetryBudget RetryBudgetConfig
+
+	// OptionalServices lists services ("user", "trip", "payment") whose outage does not make the gateway unready.
+	OptionalServices []string
+}
+
+// Registry owns one long-lived gRPC connection per backend service and the clients built on top of them.
+// It is created once at startup and shared by every handler and middleware.
+type Registry struct {
+	User    pb.UserServiceClient
+	Trip    pb.TripServiceClient
+	Payment pb.PaymentServiceClient
+
+	conns []*serviceConn
+}
+
+type serviceConn struct {
+	name     string
+	conn     *grpc.ClientConn
+	breaker  *breaker
+	critical bool
+}
+
+func (cfg Config) isCritical(name string) bool {
+	return !slices.Contains(cfg.OptionalServices, name)
+}
+
+// NewRegistry creates the connections to the user, trip and payment services.
+// Connections are established lazily and re-established by gRPC on failure, so a backend being down at startup is not fatal.
+// Extra dial options are appended to the defaults, e.g. a custom dialer for in-process servers.
+func NewRegistry(cfg Config, opts ...grpc.DialOption) (*Registry, error) {
+	svcConfig, err := serviceConfig(cfg.LoadBalancing)
+	if err != nil {
+		return nil, err
+	}
+	opts = append([]grpc.DialOption{grpc.WithDefaultServiceConfig(svcConfig)}, opts...)
+
+	r := &Registry{}
+
+	userConn, err := r.dial("user", cfg.UserHost, cfg.UserTLS, cfg, opts)
+	if err != nil {
+		r.Close()
+		return nil, err
+	}
+
+	tripConn, err := r.dial("trip", cfg.TripHost, cfg.TripTLS, cfg, opts)
+	if err != nil {
+		r.Close()
+		return nil, err
+	}
+
+	paymentConn, err := r.dial("payment", cfg.PaymentHost, cfg.PaymentTLS, cfg, opts)
+	if err != nil {
+		r.Close()
+		return nil, err
+	}
+
+	r.User = pb.NewUserServiceClient(userConn)
+	r.Trip = pb.NewTripServiceClient(tripConn)
+	r.Payment = pb.NewPaymentServiceClient(paymentConn)
+
+	return r, nil
+}
+
+func (r *Registry) dial(name, target string, tlsConfig TLSConfig, cfg Config, opts []grpc.DialOption) (*grpc.ClientConn, error) {
+	if strings.TrimSpace(target) == "" {
+		return nil, fmt.Errorf("%s service: target address is empty", name)
+	}
+
+	creds, err := tlsConfig.transportCredentials(strings.TrimSpace(target))
+	if err != nil {
+		return nil, fmt.Errorf("%s service: %w", name, err)
+	}
+
+	target, resolverOpts, err := resolveTarget(name, target)
+	if err != nil {
+		return nil, err
+	}
+
+	b := newBreaker(name, cfg.Breaker)
+	retries := newRetrier(cfg.RetryPolicies, cfg.RetryBudget)
+
+	timeouts := methodTimeouts(cfg.MethodTimeouts)
+	if timeouts == nil {
+		timeouts = DefaultMethodTimeouts
+	}
+
+	// The method timeout bounds all retries together. Retries wrap the breaker so every attempt is counted
+	// and an open circuit stops further attempts
+	dialOpts := append([]grpc.DialOption{
+		grpc.WithTransportCredentials(creds),
+		grpc.WithChainUnaryInterceptor(timeouts.unaryInterceptor, retries.unaryInterceptor, b.unaryInterceptor),
+	}, resolverOpts...)
+	dialOpts = append(dialOpts, opts...)
+
+	conn, err := grpc.NewClient(target, dialOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("%s service: %w", name, err)
+	}
+
+	r.conns = append(r.conns, &serviceConn{name: name, conn: conn, breaker: b, critical: cfg.isCritical(name)})
+	return conn, nil
+}
+
+// Close closes every backend connection.
+func (r *Registry) Close() error {
+	var errs []error
+	for _, c := range r.conns {
+		if err := c.conn.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("%s service: %w", c.name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,163 @@
+package backend
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/haiyen11231/eco-taxi-api-gateway/internal/grpc/pb"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// replica is an in-process trip service counting the calls it serves
+type replica struct {
+	pb.UnimplementedTripServiceServer
+	calls  atomic.Int64
+	health *health.Server
+}
+
+func (r *replica) SearchTripPreview(context.Context, *pb.SearchTripPreviewRequest) (*pb.SearchTripPreviewResponse, error) {
+	r.calls.Add(1)
+	return &pb.SearchTripPreviewResponse{}, nil
+}
+
+// startReplicas serves a trip service replica per address and returns a dial option connecting to them in memory
+func startReplicas(t *testing.T, addrs ...string) (map[string]*replica, grpc.DialOption) {
+	t.Helper()
+
+	replicas := map[string]*replica{}
+	listeners := map[string]*bufconn.Listener{}
+	for _, addr := range addrs {
+		r := &replica{health: health.NewServer()}
+		lis := bufconn.Listen(1 << 20)
+
+		srv := grpc.NewServer()
+		pb.RegisterTripServiceServer(srv, r)
+		healthpb.RegisterHealthServer(srv, r.health)
+		go srv.Serve(lis)
+		t.Cleanup(srv.Stop)
+
+		replicas[addr], listeners[addr] = r, lis
+	}
+
+	dialer := grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+		lis, ok := listeners[addr]
+		if !ok {
+			// The user and payment services aren't served; their connections just stay idle
+			return nil, net.ErrClosed
+		}
+		return lis.DialContext(ctx)
+	})
+	return replicas, dialer
+}
+
+func newTestRegistry(t *testing.T, policy string, addrs []string, dialer grpc.DialOption) *Registry {
+	t.Helper()
+
+	r, err := NewRegistry(Config{
+		UserHost:      "passthrough:///user",
+		TripHost:      strings.Join(addrs, ","),
+		PaymentHost:   "passthrough:///payment",
+		LoadBalancing: policy,
+	}, dialer)
+	if err != nil {
+		t.Fatalf("NewRegistry: %v", err)
+	}
+	t.Cleanup(func() { r.Close() })
+	return r
+}
+
+// search makes n calls through the registry's trip client
+func search(t *testing.T, r *Registry, n int) {
+	t.Helper()
+
+	for i := 0; i < n; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		_, err := r.Trip.SearchTripPreview(ctx, &pb.SearchTripPreviewRequest{}, grpc.WaitForReady(true))
+		cancel()
+		if err != nil {
+			t.Fatalf("SearchTripPreview: %v", err)
+		}
+	}
+}
+
+func resetCalls(replicas map[string]*replica) {
+	for _, r := range replicas {
+		r.calls.Store(0)
+	}
+}
+
+func TestRegistrySpreadsCallsOverReplicas(t *testing.T) {
+	for _, policy := range []string{RoundRobin, LeastRequest} {
+		t.Run(policy, func(t *testing.T) {
+			addrs := []string{"trip-0:5003", "trip-1:5003", "trip-2:5003"}
+			replicas, dialer := startReplicas(t, addrs...)
+			r := newTestRegistry(t, policy, addrs, dialer)
+
+			// Letting every replica's subchannel become ready before counting
+			waitFor(t, func() bool {
+				resetCalls(replicas)
+				search(t, r, 30)
+				for _, rep := range replicas {
+					if rep.calls.Load() == 0 {
+						return false
+					}
+				}
+				return true
+			})
+		})
+	}
+}
+
+func TestRegistrySkipsNotServingReplica(t *testing.T) {
+	addrs := []string{"trip-0:5003", "trip-1:5003"}
+	replicas, dialer := startReplicas(t, addrs...)
+	r := newTestRegistry(t, RoundRobin, addrs, dialer)
+	search(t, r, 10)
+
+	replicas["trip-1:5003"].health.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+	waitFor(t, func() bool {
+		resetCalls(replicas)
+		search(t, r, 20)
+		return replicas["trip-1:5003"].calls.Load() == 0 && replicas["trip-0:5003"].calls.Load() == 20
+	})
+
+	// The replica rejoins the rotation once it reports SERVING again
+	replicas["trip-1:5003"].health.SetServingStatus("", healthpb.HealthCheckResponse_SERVING)
+	waitFor(t, func() bool {
+		resetCalls(replicas)
+		search(t, r, 20)
+		return replicas["trip-1:5003"].calls.Load() > 0
+	})
+}
+
+func TestRegistryRejectsURIInAddressList(t *testing.T) {
+	_, err := NewRegistry(Config{
+		UserHost:    "user:5002",
+		TripHost:    "trip-0:5003,dns:///trip-1:5003",
+		PaymentHost: "payment:5004",
+	})
+	if err == nil {
+		t.Fatal("NewRegistry accepted a URI inside an address list")
+	}
+}
+
+// waitFor retries cond until it holds, failing the test after a few seconds
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
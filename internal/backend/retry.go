@@ -0,0 +1,173 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/haiyen11231/eco-taxi-api-gateway/internal/grpc/pb"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// IdempotencyKeyHeader is the outgoing metadata key carrying the client's idempotency key.
+const IdempotencyKeyHeader = "idempotency-key"
+
+// RetryPolicy describes how failed calls to one RPC method are retried.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts, including the first one.
+	MaxAttempts int
+	// InitialBackoff is the upper bound of the delay before the first retry.
+	InitialBackoff time.Duration
+	// MaxBackoff caps the delay between attempts.
+	MaxBackoff time.Duration
+	// Multiplier grows the backoff after every attempt.
+	Multiplier float64
+	// RetryableCodes are the status codes that trigger a retry.
+	RetryableCodes []codes.Code
+}
+
+// RetryBudgetConfig limits retries per backend so they cannot amplify an outage.
+// Every failed call spends one token and every successful call earns TokenRatio tokens back;
+// retries are only made while more than half of MaxTokens is available.
+type RetryBudgetConfig struct {
+	MaxTokens  float64
+	TokenRatio float64
+}
+
+var readPolicy = RetryPolicy{
+	MaxAttempts:    3,
+	InitialBackoff: 50 * time.Millisecond,
+	MaxBackoff:     time.Second,
+	Multiplier:     2,
+	RetryableCodes: []codes.Code{codes.Unavailable},
+}
+
+// DefaultRetryPolicies covers the read-only methods, which are always safe to retry.
+var DefaultRetryPolicies = map[string]RetryPolicy{
+	pb.UserService_GetUser_FullMethodName:               readPolicy,
+	pb.UserService_AuthenticateUser_FullMethodName:      readPolicy,
+	pb.TripService_SearchTripPreview_FullMethodName:     readPolicy,
+	pb.TripService_GetIncompletedBooking_FullMethodName: readPolicy,
+	pb.TripService_GetBookingHistory_FullMethodName:     readPolicy,
+	pb.PaymentService_GetCards_FullMethodName:           readPolicy,
+}
+
+// DefaultIdempotentPolicy applies to methods without a policy of their own, such as ConfirmBooking,
+// CreateCard or ChangePassword, but only when the call carries an idempotency key.
+// Without a key those calls are never retried.
+var DefaultIdempotentPolicy = readPolicy
+
+const (
+	defaultBudgetMaxTokens  = 10
+	defaultBudgetTokenRatio = 0.1
+)
+
+// WithIdempotencyKey attaches the client's Idempotency-Key header to the outgoing call as metadata. The
+// backend uses it to recognise a repeated request, so the retry interceptor may retry the call under
+// DefaultIdempotentPolicy even though it changes data; the handlers of mutating routes pass the header on
+// through it. An empty key leaves the context unchanged, and the call is never retried.
+func WithIdempotencyKey(ctx context.Context, key string) context.Context {
+	if key == "" {
+		return ctx
+	}
+	return metadata.AppendToOutgoingContext(ctx, IdempotencyKeyHeader, key)
+}
+
+type retrier struct {
+	policies   map[string]RetryPolicy
+	idempotent RetryPolicy
+
+	mu        sync.Mutex
+	tokens    float64
+	maxTokens float64
+	ratio     float64
+}
+
+func newRetrier(policies map[string]RetryPolicy, budget RetryBudgetConfig) *retrier {
+	if policies == nil {
+		policies = DefaultRetryPolicies
+	}
+	if budget.MaxTokens <= 0 {
+		budget.MaxTokens = defaultBudgetMaxTokens
+	}
+	if budget.TokenRatio <= 0 {
+		budget.TokenRatio = defaultBudgetTokenRatio
+	}
+
+	return &retrier{
+		policies:   policies,
+		idempotent: DefaultIdempotentPolicy,
+		tokens:     budget.MaxTokens,
+		maxTokens:  budget.MaxTokens,
+		ratio:      budget.TokenRatio,
+	}
+}
+
+// policy returns the retry policy for a call, if it may be retried at all.
+func (r *retrier) policy(ctx context.Context, method string) (RetryPolicy, bool) {
+	if p, ok := r.policies[method]; ok {
+		return p, true
+	}
+
+	md, _ := metadata.FromOutgoingContext(ctx)
+	if len(md.Get(IdempotencyKeyHeader)) > 0 {
+		return r.idempotent, true
+	}
+	return RetryPolicy{}, false
+}
+
+// record updates the retry budget and reports whether a retry is still affordable.
+func (r *retrier) record(failed bool) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if failed {
+		r.tokens = max(r.tokens-1, 0)
+	} else {
+		r.tokens = min(r.tokens+r.ratio, r.maxTokens)
+	}
+	return r.tokens > r.maxTokens/2
+}
+
+// backoff returns a randomized delay for the given retry ("full jitter").
+func (p RetryPolicy) backoff(retry int) time.Duration {
+	ceiling := float64(p.InitialBackoff) * math.Pow(p.Multiplier, float64(retry))
+	ceiling = min(ceiling, float64(p.MaxBackoff))
+	return time.Duration(rand.Float64() * ceiling)
+}
+
+func (r *retrier) unaryInterceptor(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	policy, ok := r.policy(ctx, method)
+	if !ok || policy.MaxAttempts <= 1 {
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+
+	for attempt := 0; ; attempt++ {
+		err := invoker(ctx, method, req, reply, cc, opts...)
+
+		retryable := slices.Contains(policy.RetryableCodes, status.Code(err))
+		affordable := r.record(err != nil && retryable)
+
+		// An open circuit will not close within the backoff, so retrying only delays the 503
+		var open *CircuitOpenError
+		if !retryable || errors.As(err, &open) || !affordable || attempt+1 >= policy.MaxAttempts {
+			return err
+		}
+
+		timer := time.NewTimer(policy.backoff(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package backend
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTargetHost(t *testing.T) {
+	tests := []struct {
+		target string
+		want   string
+	}{
+		{"trip-service:5003", "trip-service"},
+		{"10.0.0.5:5001", "10.0.0.5"},
+		{"[::1]:5001", "::1"},
+		{"trip-service", "trip-service"},
+		{"dns:///trip-service:5003", "trip-service"},
+		{"dns://8.8.8.8/trip-service:5003", "trip-service"},
+		{"10.0.0.1:5003,10.0.0.2:5003", ""},
+	}
+	for _, tt := range tests {
+		if got := targetHost(tt.target); got != tt.want {
+			t.Errorf("targetHost(%q) = %q, want %q", tt.target, got, tt.want)
+		}
+	}
+}
+
+func TestTransportCredentialsNeedServerNameForAddressLists(t *testing.T) {
+	cfg := TLSConfig{Enabled: true, CAFile: writeCA(t, newCA(t))}
+
+	if _, err := cfg.transportCredentials("10.0.0.1:5003,10.0.0.2:5003"); err == nil {
+		t.Error("credentials built for an address list without a server name")
+	}
+
+	cfg.ServerName = "trip-service"
+	if _, err := cfg.transportCredentials("10.0.0.1:5003,10.0.0.2:5003"); err != nil {
+		t.Errorf("credentials with a server name: %v", err)
+	}
+}
+
+func TestVerifyConnectionChecksDialedIP(t *testing.T) {
+	ca := newCA(t)
+	caFile := writeCA(t, ca)
+	leaf := ca.issue(t, "trip-service")
+
+	tests := []struct {
+		name       string
+		serverName string
+		wantErr    bool
+	}{
+		{"matching name", "trip-service", false},
+		// The handshake reports no server name for IP targets, which must not skip the host check
+		{"IP address without SAN", "10.0.0.5", true},
+		{"other name", "payment-service", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &certReloader{cfg: TLSConfig{CAFile: caFile}, serverName: tt.serverName}
+			if err := r.reload(); err != nil {
+				t.Fatal(err)
+			}
+
+			err := r.verifyConnection(tls.ConnectionState{PeerCertificates: []*x509.Certificate{leaf}})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("verifyConnection() error = %v, want error %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newCA(t *testing.T) testCA {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testCA{cert: cert, key: key}
+}
+
+// issue signs a server certificate for dnsName
+func (ca testCA) issue(t *testing.T, dnsName string) *x509.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: dnsName},
+		DNSNames:     []string{dnsName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func writeCA(t *testing.T, ca testCA) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
@@ -0,0 +1,726 @@
+package config
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"maps"
+	"net"
+	"net/netip"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/haiyen11231/eco-taxi-api-gateway/internal/apikey"
+	"github.com/haiyen11231/eco-taxi-api-gateway/internal/auth"
+	"github.com/haiyen11231/eco-taxi-api-gateway/internal/backend"
+	"github.com/haiyen11231/eco-taxi-api-gateway/internal/loginguard"
+	"github.com/haiyen11231/eco-taxi-api-gateway/internal/middleware"
+	"github.com/haiyen11231/eco-taxi-api-gateway/internal/notify"
+	"github.com/haiyen11231/eco-taxi-api-gateway/internal/telemetry"
+	"github.com/haiyen11231/eco-taxi-api-gateway/internal/verification"
+)
+
+// Config is the gateway configuration. Every setting is read, from lowest to highest precedence, from the
+// defaults, the YAML file, the environment variable named by its env tags and the flag named by its yaml path,
+// e.g. -backends.user.host. Nested structs' env tags prefix the names of their fields' variables, and
+// settings without an env tag are only read from the file. Settings tagged secret:"true" are redacted for display.
+type Config struct {
+	Server  ServerConfig  `yaml:"server"`
+	Admin   AdminConfig   `yaml:"admin"`
+	CORS    CORSConfig    `yaml:"cors"`
+	Auth    AuthConfig    `yaml:"auth"`
+	APIKeys APIKeysConfig `yaml:"api_keys"`
+	Redis   RedisConfig   `yaml:"redis"`
+	// Verification configures the one-time codes sent to users. Notifier delivers the emails and SMS the
+	// text messages, such as the codes confirming phone numbers at signup.
+	Verification VerificationConfig `yaml:"verification"`
+	Notifier     NotifierConfig     `yaml:"notifier" env:"NOTIFIER_"`
+	SMS          NotifierConfig     `yaml:"sms" env:"SMS_"`
+	Backends     BackendsConfig     `yaml:"backends"`
+	Log          LogConfig          `yaml:"log"`
+	Tracing      TracingConfig      `yaml:"tracing"`
+}
+
+type ServerConfig struct {
+	Port           string        `yaml:"port" env:"PORT"`
+	RequestTimeout time.Duration `yaml:"request_timeout" env:"REQUEST_TIMEOUT"`
+	// RouteTimeouts caps the request timeout per route, keyed by "METHOD /path"
+	RouteTimeouts map[string]time.Duration `yaml:"route_timeouts" env:"ROUTE_TIMEOUTS"`
+	// ErrorFormat is "problem" for RFC 7807 errors or "legacy" for the {code, error, message} shape
+	ErrorFormat string `yaml:"error_format" env:"ERROR_FORMAT"`
+	// TrustedProxies lists the IPs and CIDRs of the proxies whose X-Forwarded-For header gives the client IP.
+	// Without any, the client IP is the peer's address and the header is ignored.
+	TrustedProxies []string `yaml:"trusted_proxies" env:"TRUSTED_PROXIES"`
+
+	ReadTimeout  time.Duration `yaml:"read_timeout" env:"SERVER_READ_TIMEOUT"`
+	WriteTimeout time.Duration `yaml:"write_timeout" env:"SERVER_WRITE_TIMEOUT"`
+	IdleTimeout  time.Duration `yaml:"idle_timeout" env:"SERVER_IDLE_TIMEOUT"`
+	// DrainDelay is how long readiness fails before the server stops accepting requests on shutdown,
+	// giving load balancers time to take the instance out of rotation
+	DrainDelay time.Duration `yaml:"drain_delay" env:"SHUTDOWN_DRAIN_DELAY"`
+	// ShutdownGracePeriod bounds the wait for in-flight requests on shutdown
+	ShutdownGracePeriod time.Duration `yaml:"shutdown_grace_period" env:"SHUTDOWN_GRACE_PERIOD"`
+}
+
+type AdminConfig struct {
+	// Addr is the listen address of the admin server, which must not be reachable by clients
+	Addr string `yaml:"addr" env:"ADMIN_ADDR"`
+	// Token must be sent as a Bearer token to every admin endpoint. It can only be left empty when Addr is a
+	// loopback address.
+	Token string `yaml:"token" env:"ADMIN_TOKEN" secret:"true"`
+}
+
+type CORSConfig struct {
+	Default CORSPolicy `yaml:"default" env:"CORS_"`
+	// Routes replaces the default policy on the routes under each path prefix, e.g. "/v1/payment". Only
+	// allow_origins and allow_credentials must be given; the other fields fall back to the default policy.
+	// Route policies can only be set in the config file.
+	Routes map[string]CORSPolicy `yaml:"routes"`
+}
+
+type CORSPolicy struct {
+	AllowOrigins     []string      `yaml:"allow_origins" env:"ALLOW_ORIGINS"`
+	AllowMethods     []string      `yaml:"allow_methods" env:"ALLOW_METHODS"`
+	AllowHeaders     []string      `yaml:"allow_headers" env:"ALLOW_HEADERS"`
+	ExposeHeaders    []string      `yaml:"expose_headers" env:"EXPOSE_HEADERS"`
+	AllowCredentials bool          `yaml:"allow_credentials" env:"ALLOW_CREDENTIALS"`
+	MaxAge           time.Duration `yaml:"max_age" env:"MAX_AGE"`
+}
+
+type AuthConfig struct {
+	// Mode is "local" to verify access tokens in the gateway, or "remote" to check each one with the user service
+	Mode string    `yaml:"mode" env:"AUTH_MODE"`
+	JWT  JWTConfig `yaml:"jwt" env:"AUTH_JWT_"`
+	// Cache reuses the results of remote checks; a TTL of 0 turns it off
+	Cache AuthCacheConfig `yaml:"cache" env:"AUTH_CACHE_"`
+	// Session configures how refresh tokens are rotated and handed to clients
+	Session SessionConfig `yaml:"session" env:"AUTH_SESSION_"`
+	// LoginGuard throttles failed logins
+	LoginGuard LoginGuardConfig `yaml:"login_guard" env:"LOGIN_GUARD_"`
+	// Routes restricts the authenticated routes under each path prefix, e.g. "/v1/payment", to callers with
+	// the given roles or scopes. The policies of all matching prefixes apply. They can only be set in the
+	// config file.
+	Routes map[string]AccessPolicy `yaml:"routes"`
+}
+
+// LoginGuardConfig holds the limits of loginguard.Config, which documents each of them, and where the
+// counts are kept.
+type LoginGuardConfig struct {
+	// Store is "memory" for a single instance, or "redis" to share the counts across a cluster
+	Store            string        `yaml:"store" env:"STORE"`
+	MaxFailures      int           `yaml:"max_failures" env:"MAX_FAILURES"`
+	MaxFailuresPerIP int           `yaml:"max_failures_per_ip" env:"MAX_FAILURES_PER_IP"`
+	DelayAfter       int           `yaml:"delay_after" env:"DELAY_AFTER"`
+	BaseDelay        time.Duration `yaml:"base_delay" env:"BASE_DELAY"`
+	MaxDelay         time.Duration `yaml:"max_delay" env:"MAX_DELAY"`
+	Window           time.Duration `yaml:"window" env:"WINDOW"`
+	Lockout          time.Duration `yaml:"lockout" env:"LOCKOUT"`
+}
+
+// AccessPolicy mirrors middleware.AccessPolicy, which documents each setting.
+type AccessPolicy struct {
+	Roles      []string            `yaml:"roles"`
+	Scopes     []string            `yaml:"scopes"`
+	RoleScopes map[string][]string `yaml:"role_scopes"`
+}
+
+type AuthCacheConfig struct {
+	TTL        time.Duration `yaml:"ttl" env:"TTL"`
+	MaxEntries int           `yaml:"max_entries" env:"MAX_ENTRIES"`
+}
+
+// SessionConfig holds the settings of auth.SessionConfig, which documents them, and where the sessions are kept.
+type SessionConfig struct {
+	// Store is "memory" for a single instance, or "redis" to keep sessions across restarts and share them
+	// across a cluster
+	Store          string        `yaml:"store" env:"STORE"`
+	Transport      string        `yaml:"transport" env:"TRANSPORT"`
+	Lifetime       time.Duration `yaml:"lifetime" env:"LIFETIME"`
+	CookieDomain   string        `yaml:"cookie_domain" env:"COOKIE_DOMAIN"`
+	CookieSameSite string        `yaml:"cookie_same_site" env:"COOKIE_SAME_SITE"`
+}
+
+// JWTConfig mirrors auth.JWTConfig, which documents each setting.
+type JWTConfig struct {
+	Secret              string        `yaml:"secret" env:"SECRET" secret:"true"`
+	JWKSURL             string        `yaml:"jwks_url" env:"JWKS_URL"`
+	JWKSFile            string        `yaml:"jwks_file" env:"JWKS_FILE"`
+	JWKSRefreshInterval time.Duration `yaml:"jwks_refresh_interval" env:"JWKS_REFRESH_INTERVAL"`
+	Issuer              string        `yaml:"issuer" env:"ISSUER"`
+	Audience            string        `yaml:"audience" env:"AUDIENCE"`
+	UserIDClaim         string        `yaml:"user_id_claim" env:"USER_ID_CLAIM"`
+	RolesClaim          string        `yaml:"roles_claim" env:"ROLES_CLAIM"`
+	ScopesClaim         string        `yaml:"scopes_claim" env:"SCOPES_CLAIM"`
+	Leeway              time.Duration `yaml:"leeway" env:"LEEWAY"`
+}
+
+type APIKeysConfig struct {
+	// File holds the partner API keys, hashed. It is created when the first key is issued.
+	File string `yaml:"file" env:"API_KEYS_FILE"`
+}
+
+// RedisConfig is the Redis server, or any server speaking its protocol, that state shared by the
+// gateway instances of a cluster is kept in.
+type RedisConfig struct {
+	Addr     string `yaml:"addr" env:"REDIS_ADDR"`
+	Password string `yaml:"password" env:"REDIS_PASSWORD" secret:"true"`
+	DB       int    `yaml:"db" env:"REDIS_DB"`
+	// KeyPrefix starts every key the gateway writes
+	KeyPrefix string `yaml:"key_prefix" env:"REDIS_KEY_PREFIX"`
+}
+
+// VerificationConfig holds the settings of verification.Config, which documents them, and where the
+// codes are kept.
+type VerificationConfig struct {
+	// Store is "memory" for a single instance, or "redis" to share the codes across a cluster
+	Store          string        `yaml:"store" env:"VERIFICATION_STORE"`
+	CodeTTL        time.Duration `yaml:"code_ttl" env:"VERIFICATION_CODE_TTL"`
+	MaxAttempts    int           `yaml:"max_attempts" env:"VERIFICATION_MAX_ATTEMPTS"`
+	MaxCodes       int           `yaml:"max_codes" env:"VERIFICATION_MAX_CODES"`
+	Window         time.Duration `yaml:"window" env:"VERIFICATION_WINDOW"`
+	ResendInterval time.Duration `yaml:"resend_interval" env:"VERIFICATION_RESEND_INTERVAL"`
+	// DataKey is the base64 encoded 32-byte key encrypting pending registrations and other data kept with
+	// the codes. It is required with the redis store; the memory store generates one at startup.
+	DataKey string `yaml:"data_key" env:"VERIFICATION_DATA_KEY" secret:"true"`
+	// PasswordResetLinkURL, when set, adds a link to this page, carrying the email and code as query
+	// parameters, to password reset messages
+	PasswordResetLinkURL string `yaml:"password_reset_link_url" env:"PASSWORD_RESET_LINK_URL"`
+}
+
+// NotifierConfig selects where a channel of messages to users, such as verification codes, is delivered.
+type NotifierConfig struct {
+	// Sink is "log" to write messages to the gateway's log, or "file" to append them to File
+	Sink string `yaml:"sink" env:"SINK"`
+	File string `yaml:"file" env:"FILE"`
+	// LogBodies makes the log sink write the messages' text, codes included. Only for local development.
+	LogBodies bool `yaml:"log_bodies" env:"LOG_BODIES"`
+}
+
+type BackendsConfig struct {
+	User    ServiceConfig `yaml:"user" env:"GRPC_USER_"`
+	Trip    ServiceConfig `yaml:"trip" env:"GRPC_TRIP_"`
+	Payment ServiceConfig `yaml:"payment" env:"GRPC_PAYMENT_"`
+
+	LoadBalancing    string   `yaml:"load_balancing" env:"GRPC_LB_POLICY"`
+	OptionalServices []string `yaml:"optional_services" env:"READINESS_OPTIONAL_SERVICES"`
+	// RPCTimeouts overrides backend.DefaultMethodTimeouts, keyed by method name
+	RPCTimeouts map[string]time.Duration `yaml:"rpc_timeouts" env:"RPC_TIMEOUTS"`
+	Breaker     BreakerConfig            `yaml:"breaker" env:"BREAKER_"`
+}
+
+type ServiceConfig struct {
+	Host string    `yaml:"host" env:"HOST"`
+	TLS  TLSConfig `yaml:"tls" env:"TLS_"`
+}
+
+type TLSConfig struct {
+	Enabled    bool   `yaml:"enabled" env:"ENABLED"`
+	CAFile     string `yaml:"ca_file" env:"CA_FILE"`
+	CertFile   string `yaml:"cert_file" env:"CERT_FILE"`
+	KeyFile    string `yaml:"key_file" env:"KEY_FILE"`
+	ServerName string `yaml:"server_name" env:"SERVER_NAME"`
+}
+
+type BreakerConfig struct {
+	FailureThreshold int           `yaml:"failure_threshold" env:"FAILURE_THRESHOLD"`
+	CoolDown         time.Duration `yaml:"cooldown" env:"COOLDOWN"`
+	HalfOpenMaxCalls int           `yaml:"half_open_max_calls" env:"HALF_OPEN_MAX_CALLS"`
+}
+
+type LogConfig struct {
+	Level string `yaml:"level" env:"LOG_LEVEL"`
+}
+
+type TracingConfig struct {
+	Exporter     string `yaml:"exporter" env:"TRACING_EXPORTER"`
+	OTLPEndpoint string `yaml:"otlp_endpoint" env:"TRACING_OTLP_ENDPOINT"`
+	OTLPInsecure bool   `yaml:"otlp_insecure" env:"TRACING_OTLP_INSECURE"`
+	ServiceName  string `yaml:"service_name" env:"OTEL_SERVICE_NAME"`
+}
+
+// Default returns the configuration used for every setting that no source sets.
+func Default() Config {
+	return Config{
+		Server: ServerConfig{
+			Port:           "8080",
+			RequestTimeout: 10 * time.Second,
+			RouteTimeouts:  map[string]time.Duration{},
+			ErrorFormat:    "problem",
+
+			ReadTimeout:         15 * time.Second,
+			WriteTimeout:        30 * time.Second,
+			IdleTimeout:         60 * time.Second,
+			DrainDelay:          5 * time.Second,
+			ShutdownGracePeriod: 30 * time.Second,
+		},
+		Admin: AdminConfig{
+			Addr: "127.0.0.1:9090",
+		},
+		CORS: CORSConfig{
+			Default: CORSPolicy{
+				AllowOrigins:     []string{"http://localhost:5173"},
+				AllowMethods:     []string{"GET", "POST", "PATCH", "DELETE", "OPTIONS"},
+				AllowHeaders:     []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
+				ExposeHeaders:    []string{"Link"},
+				AllowCredentials: true,
+				MaxAge:           5 * time.Minute,
+			},
+		},
+		Auth: AuthConfig{
+			Mode: auth.ModeRemote,
+			JWT: JWTConfig{
+				JWKSRefreshInterval: 5 * time.Minute,
+				UserIDClaim:         "sub",
+				RolesClaim:          "roles",
+				ScopesClaim:         "scope",
+				Leeway:              30 * time.Second,
+			},
+			Cache: AuthCacheConfig{
+				TTL:        30 * time.Second,
+				MaxEntries: 10000,
+			},
+			Session: SessionConfig{
+				Store:          "memory",
+				Transport:      auth.TransportCookie,
+				Lifetime:       24 * time.Hour,
+				CookieSameSite: "lax",
+			},
+			LoginGuard: LoginGuardConfig{
+				Store:            "memory",
+				MaxFailures:      5,
+				MaxFailuresPerIP: 50,
+				DelayAfter:       2,
+				BaseDelay:        time.Second,
+				MaxDelay:         30 * time.Second,
+				Window:           15 * time.Minute,
+				Lockout:          15 * time.Minute,
+			},
+			// Partner API keys act for their rider account on the trip routes, so they must be granted that
+			Routes: map[string]AccessPolicy{
+				"/v1/trip": {RoleScopes: map[string][]string{apikey.Role: {"trips:write"}}},
+			},
+		},
+		APIKeys: APIKeysConfig{
+			File: "api_keys.json",
+		},
+		Redis: RedisConfig{
+			KeyPrefix: "eco-taxi:",
+		},
+		Verification: VerificationConfig{
+			Store:          "memory",
+			CodeTTL:        10 * time.Minute,
+			MaxAttempts:    5,
+			MaxCodes:       5,
+			Window:         time.Hour,
+			ResendInterval: time.Minute,
+		},
+		Notifier: NotifierConfig{
+			Sink: notify.SinkLog,
+			File: "notifications.log",
+		},
+		SMS: NotifierConfig{
+			Sink: notify.SinkLog,
+			File: "sms.log",
+		},
+		Backends: BackendsConfig{
+			LoadBalancing: backend.RoundRobin,
+			RPCTimeouts:   maps.Clone(backend.DefaultMethodTimeouts),
+			Breaker: BreakerConfig{
+				FailureThreshold: 5,
+				CoolDown:         30 * time.Second,
+				HalfOpenMaxCalls: 1,
+			},
+		},
+		Log: LogConfig{
+			Level: "info",
+		},
+		Tracing: TracingConfig{
+			Exporter:    telemetry.ExporterNone,
+			ServiceName: telemetry.DefaultServiceName,
+		},
+	}
+}
+
+// Validate reports every invalid setting, each prefixed with its yaml path.
+func (c *Config) Validate() error {
+	var errs []error
+	invalid := func(path, format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%s: %s", path, fmt.Sprintf(format, args...)))
+	}
+
+	if c.Server.Port == "" {
+		invalid("server.port", "must be set")
+	}
+	if c.Server.RequestTimeout <= 0 {
+		invalid("server.request_timeout", "must be positive")
+	}
+	for route, timeout := range c.Server.RouteTimeouts {
+		method, path, ok := strings.Cut(route, " ")
+		if !ok || method == "" || !strings.HasPrefix(path, "/") {
+			invalid("server.route_timeouts", "%q is not a \"METHOD /path\" key", route)
+		}
+		if timeout <= 0 {
+			invalid("server.route_timeouts", "timeout of %q must be positive", route)
+		}
+	}
+	if !slices.Contains([]string{"problem", "legacy"}, c.Server.ErrorFormat) {
+		invalid("server.error_format", "must be problem or legacy, got %q", c.Server.ErrorFormat)
+	}
+	for _, proxy := range c.Server.TrustedProxies {
+		if _, err := netip.ParseAddr(proxy); err == nil {
+			continue
+		}
+		if _, err := netip.ParsePrefix(proxy); err != nil {
+			invalid("server.trusted_proxies", "%q is not an IP address or CIDR", proxy)
+		}
+	}
+
+	for _, timeout := range []struct {
+		path  string
+		value time.Duration
+	}{
+		{"server.read_timeout", c.Server.ReadTimeout},
+		{"server.write_timeout", c.Server.WriteTimeout},
+		{"server.idle_timeout", c.Server.IdleTimeout},
+		{"server.drain_delay", c.Server.DrainDelay},
+		{"server.shutdown_grace_period", c.Server.ShutdownGracePeriod},
+	} {
+		if timeout.value < 0 {
+			invalid(timeout.path, "must not be negative")
+		}
+	}
+	if c.Server.WriteTimeout > 0 && c.Server.WriteTimeout <= c.Server.RequestTimeout {
+		invalid("server.write_timeout", "must be longer than server.request_timeout (%s), or responses are cut off", c.Server.RequestTimeout)
+	}
+
+	if c.Admin.Addr == "" {
+		invalid("admin.addr", "must be set")
+	} else if c.Admin.Token == "" && !isLoopback(c.Admin.Addr) {
+		invalid("admin.token", "must be set when admin.addr (%s) is not a loopback address, as the admin endpoints manage API keys and expose profiles", c.Admin.Addr)
+	}
+
+	validateCORSPolicy(invalid, "cors.default", c.CORS.Default)
+	for prefix, policy := range c.CORS.Routes {
+		if !strings.HasPrefix(prefix, "/") {
+			invalid("cors.routes", "%q is not a path prefix", prefix)
+		}
+		validateCORSPolicy(invalid, "cors.routes."+prefix, policy)
+	}
+
+	switch c.Auth.Mode {
+	case auth.ModeRemote:
+	case auth.ModeLocal:
+		jwt := c.Auth.JWT
+		if jwt.Secret == "" && jwt.JWKSURL == "" && jwt.JWKSFile == "" {
+			invalid("auth.jwt", "secret, jwks_url or jwks_file must be set in local mode")
+		}
+		if jwt.Secret != "" && len(jwt.Secret) < 32 {
+			invalid("auth.jwt.secret", "must be at least 32 bytes for HS256")
+		}
+		if jwt.JWKSURL != "" && jwt.JWKSFile != "" {
+			invalid("auth.jwt", "jwks_url and jwks_file can't both be set")
+		}
+		if jwt.Audience == "" {
+			invalid("auth.jwt.audience", "must be set in local mode")
+		}
+		if jwt.UserIDClaim == "" {
+			invalid("auth.jwt.user_id_claim", "must be set")
+		}
+		if jwt.JWKSRefreshInterval <= 0 {
+			invalid("auth.jwt.jwks_refresh_interval", "must be positive")
+		}
+		if jwt.Leeway < 0 {
+			invalid("auth.jwt.leeway", "must not be negative")
+		}
+	default:
+		invalid("auth.mode", "must be local or remote, got %q", c.Auth.Mode)
+	}
+	if c.Auth.Cache.TTL < 0 || c.Auth.Cache.TTL > 10*time.Minute {
+		invalid("auth.cache.ttl", "must be between 0 and 10m, as it delays revocations made elsewhere")
+	}
+	if c.Auth.Cache.MaxEntries <= 0 {
+		invalid("auth.cache.max_entries", "must be positive")
+	}
+	session := c.Auth.Session
+	switch session.Store {
+	case "memory":
+	case "redis":
+		if c.Redis.Addr == "" {
+			invalid("redis.addr", "must be set when auth.session.store is redis")
+		}
+	default:
+		invalid("auth.session.store", "must be memory or redis, got %q", session.Store)
+	}
+	if session.Transport != auth.TransportCookie && session.Transport != auth.TransportBody {
+		invalid("auth.session.transport", "must be %s or %s, got %q", auth.TransportCookie, auth.TransportBody, session.Transport)
+	}
+	if session.Lifetime <= 0 {
+		invalid("auth.session.lifetime", "must be positive")
+	}
+	if !slices.Contains([]string{"lax", "strict", "none"}, session.CookieSameSite) {
+		invalid("auth.session.cookie_same_site", "must be lax, strict or none, got %q", session.CookieSameSite)
+	}
+	guard := c.Auth.LoginGuard
+	switch guard.Store {
+	case "memory":
+	case "redis":
+		if c.Redis.Addr == "" {
+			invalid("redis.addr", "must be set when auth.login_guard.store is redis")
+		}
+	default:
+		invalid("auth.login_guard.store", "must be memory or redis, got %q", guard.Store)
+	}
+	if guard.MaxFailures <= 0 || guard.MaxFailuresPerIP <= 0 {
+		invalid("auth.login_guard", "max_failures and max_failures_per_ip must be positive")
+	}
+	if guard.DelayAfter < 0 || guard.DelayAfter >= guard.MaxFailures {
+		invalid("auth.login_guard.delay_after", "must be between 0 and max_failures - 1")
+	}
+	if guard.BaseDelay <= 0 || guard.MaxDelay < guard.BaseDelay {
+		invalid("auth.login_guard", "base_delay must be positive and at most max_delay")
+	}
+	if guard.Window <= 0 || guard.Lockout <= 0 {
+		invalid("auth.login_guard", "window and lockout must be positive")
+	}
+	for prefix, policy := range c.Auth.Routes {
+		if !strings.HasPrefix(prefix, "/") {
+			invalid("auth.routes", "%q is not a path prefix", prefix)
+		}
+		if len(policy.Roles) == 0 && len(policy.Scopes) == 0 && len(policy.RoleScopes) == 0 {
+			invalid("auth.routes."+prefix, "roles, scopes or role_scopes must be set")
+		}
+		if slices.Contains(policy.Roles, "") || slices.Contains(policy.Scopes, "") {
+			invalid("auth.routes."+prefix, "roles and scopes must not be empty")
+		}
+		for role, scopes := range policy.RoleScopes {
+			if role == "" || len(scopes) == 0 || slices.Contains(scopes, "") {
+				invalid("auth.routes."+prefix+".role_scopes", "%q must be a role with non-empty scopes", role)
+			}
+		}
+	}
+
+	switch c.Verification.Store {
+	case "memory":
+	case "redis":
+		if c.Redis.Addr == "" {
+			invalid("redis.addr", "must be set when verification.store is redis")
+		}
+		if c.Verification.DataKey == "" {
+			invalid("verification.data_key", "must be set when verification.store is redis")
+		}
+	default:
+		invalid("verification.store", "must be memory or redis, got %q", c.Verification.Store)
+	}
+	if key := c.Verification.DataKey; key != "" {
+		if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 32 {
+			invalid("verification.data_key", "must be 32 bytes encoded in base64")
+		}
+	}
+	if c.Verification.CodeTTL <= 0 || c.Verification.CodeTTL > time.Hour {
+		invalid("verification.code_ttl", "must be between 0 and 1h, as codes are short to guess")
+	}
+	if c.Verification.MaxAttempts <= 0 || c.Verification.MaxAttempts > 10 {
+		invalid("verification.max_attempts", "must be between 1 and 10")
+	}
+	if c.Verification.MaxCodes <= 0 {
+		invalid("verification.max_codes", "must be positive")
+	}
+	if c.Verification.Window < c.Verification.CodeTTL {
+		invalid("verification.window", "must be at least code_ttl")
+	}
+	if c.Verification.ResendInterval <= 0 {
+		invalid("verification.resend_interval", "must be positive, so codes can't be requested in a loop")
+	}
+	if link := c.Verification.PasswordResetLinkURL; link != "" && !strings.HasPrefix(link, "https://") && !strings.HasPrefix(link, "http://localhost") {
+		invalid("verification.password_reset_link_url", "must be an https URL")
+	}
+
+	validateNotifier(invalid, "notifier", c.Notifier)
+	validateNotifier(invalid, "sms", c.SMS)
+
+	if c.APIKeys.File == "" {
+		invalid("api_keys.file", "must be set")
+	}
+
+	services := map[string]ServiceConfig{"user": c.Backends.User, "trip": c.Backends.Trip, "payment": c.Backends.Payment}
+	for _, name := range []string{"user", "trip", "payment"} {
+		service := services[name]
+		if service.Host == "" {
+			invalid("backends."+name+".host", "must be set")
+		}
+		if (service.TLS.CertFile == "") != (service.TLS.KeyFile == "") {
+			invalid("backends."+name+".tls", "cert_file and key_file must be set together")
+		}
+	}
+	if !slices.Contains([]string{backend.RoundRobin, backend.LeastRequest}, c.Backends.LoadBalancing) {
+		invalid("backends.load_balancing", "must be %s or %s, got %q", backend.RoundRobin, backend.LeastRequest, c.Backends.LoadBalancing)
+	}
+	for _, name := range c.Backends.OptionalServices {
+		if _, ok := services[name]; !ok {
+			invalid("backends.optional_services", "unknown service %q", name)
+		}
+	}
+	for method, timeout := range c.Backends.RPCTimeouts {
+		if timeout <= 0 {
+			invalid("backends.rpc_timeouts", "timeout of %q must be positive", method)
+		}
+	}
+	if c.Backends.Breaker.FailureThreshold <= 0 {
+		invalid("backends.breaker.failure_threshold", "must be positive")
+	}
+	if c.Backends.Breaker.CoolDown <= 0 {
+		invalid("backends.breaker.cooldown", "must be positive")
+	}
+	if c.Backends.Breaker.HalfOpenMaxCalls <= 0 {
+		invalid("backends.breaker.half_open_max_calls", "must be positive")
+	}
+
+	if !slices.Contains([]string{"debug", "info", "warn", "error"}, strings.ToLower(c.Log.Level)) {
+		invalid("log.level", "must be debug, info, warn or error, got %q", c.Log.Level)
+	}
+
+	if !slices.Contains([]string{telemetry.ExporterNone, telemetry.ExporterStdout, telemetry.ExporterOTLP}, c.Tracing.Exporter) {
+		invalid("tracing.exporter", "must be none, stdout or otlp, got %q", c.Tracing.Exporter)
+	}
+
+	return errors.Join(errs...)
+}
+
+// validateNotifier reports a sink that doesn't exist or lacks its settings
+func validateNotifier(invalid func(path, format string, args ...any), path string, notifier NotifierConfig) {
+	switch notifier.Sink {
+	case notify.SinkLog:
+	case notify.SinkFile:
+		if notifier.File == "" {
+			invalid(path+".file", "must be set for the file sink")
+		}
+	default:
+		invalid(path+".sink", "must be %s or %s, got %q", notify.SinkLog, notify.SinkFile, notifier.Sink)
+	}
+}
+
+// isLoopback reports whether a listen address only accepts connections from the same host. An address
+// without a host listens on every interface.
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip, err := netip.ParseAddr(host)
+	return err == nil && ip.IsLoopback()
+}
+
+// validateCORSPolicy reports the origins a browser or the CORS middleware would reject
+func validateCORSPolicy(invalid func(path, format string, args ...any), path string, policy CORSPolicy) {
+	if len(policy.AllowOrigins) == 0 {
+		invalid(path+".allow_origins", "must not be empty")
+	}
+	for _, origin := range policy.AllowOrigins {
+		switch {
+		case origin == "*":
+			if policy.AllowCredentials {
+				invalid(path+".allow_origins", "\"*\" can't be combined with allow_credentials; list the origins instead")
+			}
+		case strings.Contains(origin, "*"):
+			scheme, host, _ := strings.Cut(origin, "://")
+			if scheme == "" || !strings.HasPrefix(host, "*.") || strings.Count(origin, "*") > 1 {
+				invalid(path+".allow_origins", "%q must have the form scheme://*.domain to allow subdomains", origin)
+			}
+		case !strings.HasPrefix(origin, "http://") && !strings.HasPrefix(origin, "https://"):
+			invalid(path+".allow_origins", "%q must start with http:// or https://", origin)
+		}
+	}
+	if policy.MaxAge < 0 {
+		invalid(path+".max_age", "must not be negative")
+	}
+}
+
+// CORSPolicies converts the CORS settings to the middleware's policies, filling in the fields the route
+// policies leave empty from the default policy.
+func (c *Config) CORSPolicies() (middleware.CORSPolicy, map[string]middleware.CORSPolicy) {
+	routes := map[string]middleware.CORSPolicy{}
+	for prefix, policy := range c.CORS.Routes {
+		if policy.AllowMethods == nil {
+			policy.AllowMethods = c.CORS.Default.AllowMethods
+		}
+		if policy.AllowHeaders == nil {
+			policy.AllowHeaders = c.CORS.Default.AllowHeaders
+		}
+		if policy.ExposeHeaders == nil {
+			policy.ExposeHeaders = c.CORS.Default.ExposeHeaders
+		}
+		if policy.MaxAge == 0 {
+			policy.MaxAge = c.CORS.Default.MaxAge
+		}
+		routes[prefix] = middleware.CORSPolicy(policy)
+	}
+	return middleware.CORSPolicy(c.CORS.Default), routes
+}
+
+// AccessPolicies returns the access policies of the route prefixes.
+func (c *Config) AccessPolicies() map[string]middleware.AccessPolicy {
+	routes := map[string]middleware.AccessPolicy{}
+	for prefix, policy := range c.Auth.Routes {
+		routes[prefix] = middleware.AccessPolicy(policy)
+	}
+	return routes
+}
+
+// SessionConfig returns the settings of the refresh sessions.
+func (c *Config) SessionConfig() auth.SessionConfig {
+	session := c.Auth.Session
+	return auth.SessionConfig{
+		Transport:      session.Transport,
+		Lifetime:       session.Lifetime,
+		CookieDomain:   session.CookieDomain,
+		CookieSameSite: session.CookieSameSite,
+	}
+}
+
+// LoginGuardConfig returns the limits of the login guard.
+func (c *Config) LoginGuardConfig() loginguard.Config {
+	guard := c.Auth.LoginGuard
+	return loginguard.Config{
+		MaxFailures:      guard.MaxFailures,
+		MaxFailuresPerIP: guard.MaxFailuresPerIP,
+		DelayAfter:       guard.DelayAfter,
+		BaseDelay:        guard.BaseDelay,
+		MaxDelay:         guard.MaxDelay,
+		Window:           guard.Window,
+		Lockout:          guard.Lockout,
+	}
+}
+
+// VerificationConfig returns the settings of the verification codes.
+func (c *Config) VerificationConfig() verification.Config {
+	// The key was checked by Validate; an empty one leaves verification.New to generate it
+	dataKey, _ := base64.StdEncoding.DecodeString(c.Verification.DataKey)
+	return verification.Config{
+		TTL:            c.Verification.CodeTTL,
+		MaxAttempts:    c.Verification.MaxAttempts,
+		MaxCodes:       c.Verification.MaxCodes,
+		Window:         c.Verification.Window,
+		ResendInterval: c.Verification.ResendInterval,
+		DataKey:        dataKey,
+	}
+}
+
+// BackendConfig converts the backend settings to the registry's configuration.
+func (c *Config) BackendConfig() backend.Config {
+	return backend.Config{
+		UserHost:         c.Backends.User.Host,
+		TripHost:         c.Backends.Trip.Host,
+		PaymentHost:      c.Backends.Payment.Host,
+		UserTLS:          backend.TLSConfig(c.Backends.User.TLS),
+		TripTLS:          backend.TLSConfig(c.Backends.Trip.TLS),
+		PaymentTLS:       backend.TLSConfig(c.Backends.Payment.TLS),
+		LoadBalancing:    c.Backends.LoadBalancing,
+		MethodTimeouts:   c.Backends.RPCTimeouts,
+		OptionalServices: c.Backends.OptionalServices,
+		Breaker:          backend.BreakerConfig(c.Backends.Breaker),
+	}
+}
@@ -0,0 +1,227 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigFileEnv names the YAML file to load when the -config flag is not given.
+const ConfigFileEnv = "CONFIG_FILE"
+
+// redacted replaces the value of secret settings in Redacted
+const redacted = "[REDACTED]"
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// setting is a single configuration value along with the names it is read from
+type setting struct {
+	path  string // yaml path and flag name, e.g. "backends.user.host"
+	env   string
+	value reflect.Value
+}
+
+// Load builds the configuration from the defaults, the YAML file, the environment and the command-line
+// arguments, in increasing order of precedence, and validates it.
+func Load(args []string) (*Config, error) {
+	cfg := Default()
+	settings := collectSettings(reflect.ValueOf(&cfg).Elem(), "", "")
+
+	// Recording flags while parsing, so they are applied after the file and environment they override
+	fs := flag.NewFlagSet("api_gateway", flag.ContinueOnError)
+	file := fs.String("config", os.Getenv(ConfigFileEnv), "YAML configuration `file` (env "+ConfigFileEnv+")")
+	var flags []func() error
+	for _, s := range settings {
+		if s.env == "" {
+			continue
+		}
+		fs.Func(s.path, "env "+s.env, func(value string) error {
+			flags = append(flags, func() error {
+				if err := setValue(s.value, value); err != nil {
+					return fmt.Errorf("flag -%s: %w", s.path, err)
+				}
+				return nil
+			})
+			return nil
+		})
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if *file != "" {
+		if err := loadFile(&cfg, *file); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, s := range settings {
+		if s.env == "" {
+			continue
+		}
+		value := os.Getenv(s.env)
+		if value == "" {
+			continue
+		}
+		if err := setValue(s.value, value); err != nil {
+			return nil, fmt.Errorf("env %s: %w", s.env, err)
+		}
+	}
+
+	for _, apply := range flags {
+		if err := apply(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration:\n%w", err)
+	}
+	return &cfg, nil
+}
+
+// loadFile overlays the settings of a YAML file on cfg, rejecting keys that don't match a setting
+func loadFile(cfg *Config, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("config file: %w", err)
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// collectSettings lists the leaf fields of the struct v, joining the yaml names and env tags of their parents
+func collectSettings(v reflect.Value, path, env string) []setting {
+	var settings []setting
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+
+		name := field.Tag.Get("yaml")
+		if path != "" {
+			name = path + "." + name
+		}
+
+		if field.Type.Kind() == reflect.Struct {
+			settings = append(settings, collectSettings(v.Field(i), name, env+field.Tag.Get("env"))...)
+			continue
+		}
+
+		settings = append(settings, setting{
+			path:  name,
+			env:   env + field.Tag.Get("env"),
+			value: v.Field(i),
+		})
+	}
+	return settings
+}
+
+// setValue parses a flag or environment value into v. Lists are comma-separated, and maps are comma-separated
+// "key=duration" pairs merged into the existing entries.
+func setValue(v reflect.Value, value string) error {
+	switch {
+	case v.Type() == durationType:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+
+	case v.Kind() == reflect.String:
+		v.SetString(value)
+
+	case v.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+
+	case v.Kind() == reflect.Int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(n))
+
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.String:
+		v.Set(reflect.ValueOf(splitList(value)))
+
+	case v.Kind() == reflect.Map && v.Type().Elem() == durationType:
+		if v.IsNil() {
+			v.Set(reflect.MakeMap(v.Type()))
+		}
+		for _, item := range splitList(value) {
+			key, value, ok := strings.Cut(item, "=")
+			if !ok {
+				return fmt.Errorf("%q is not a key=duration pair", item)
+			}
+			d, err := time.ParseDuration(strings.TrimSpace(value))
+			if err != nil {
+				return err
+			}
+			v.SetMapIndex(reflect.ValueOf(strings.TrimSpace(key)), reflect.ValueOf(d))
+		}
+
+	default:
+		return fmt.Errorf("unsupported setting type %s", v.Type())
+	}
+	return nil
+}
+
+// splitList parses a comma-separated value into its non-empty entries
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// Redacted returns the effective configuration as nested maps keyed by yaml name, for display. Secret settings
+// are masked and durations are formatted like "30s".
+func (c *Config) Redacted() map[string]any {
+	return displayValue(reflect.ValueOf(*c)).(map[string]any)
+}
+
+func displayValue(v reflect.Value) any {
+	switch {
+	case v.Type() == durationType:
+		return time.Duration(v.Int()).String()
+
+	case v.Kind() == reflect.Struct:
+		values := map[string]any{}
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			if field.Tag.Get("secret") == "true" && !v.Field(i).IsZero() {
+				values[field.Tag.Get("yaml")] = redacted
+				continue
+			}
+			values[field.Tag.Get("yaml")] = displayValue(v.Field(i))
+		}
+		return values
+
+	case v.Kind() == reflect.Map:
+		values := map[string]any{}
+		for _, key := range v.MapKeys() {
+			values[key.String()] = displayValue(v.MapIndex(key))
+		}
+		return values
+	}
+	return v.Interface()
+}
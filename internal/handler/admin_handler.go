@@ -0,0 +1,91 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/pprof"
+	"strings"
+
+	"github.com/haiyen11231/eco-taxi-api-gateway/internal/backend"
+	"github.com/haiyen11231/eco-taxi-api-gateway/internal/config"
+	"github.com/haiyen11231/eco-taxi-api-gateway/internal/logger"
+	"github.com/haiyen11231/eco-taxi-api-gateway/internal/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// EffectiveConfig responds with the configuration the gateway is running with, secrets redacted.
+func EffectiveConfig(cfg *config.Config) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		ctx.JSON(http.StatusOK, cfg.Redacted())
+	}
+}
+
+// Routes responds with the routes registered on the public router.
+func Routes(router *gin.Engine) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		routes := []gin.H{}
+		for _, route := range router.Routes() {
+			routes = append(routes, gin.H{
+				"method":  route.Method,
+				"path":    route.Path,
+				"handler": route.Handler,
+			})
+		}
+		ctx.JSON(http.StatusOK, routes)
+	}
+}
+
+// BackendState responds with the connection and circuit breaker state of every backend service.
+func BackendState(backends *backend.Registry) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		ctx.JSON(http.StatusOK, backends.ConnectionStates())
+	}
+}
+
+// GetLogLevel responds with the current minimum log level.
+func GetLogLevel() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		ctx.JSON(http.StatusOK, gin.H{"level": strings.ToLower(logger.Level.Level().String())})
+	}
+}
+
+// SetLogLevel changes the minimum log level at runtime, e.g. to turn on debug logs while investigating an issue.
+func SetLogLevel() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		var request struct {
+			Level string `json:"level" binding:"required"`
+		}
+
+		if err := ctx.ShouldBindJSON(&request); err != nil {
+			utils.ResponseBindError(ctx, err, "Invalid request format")
+			return
+		}
+
+		if err := logger.SetLevel(request.Level); err != nil {
+			utils.ResponseError(ctx, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		logger.FromContext(ctx).Warn("Log level changed", "level", request.Level)
+		ctx.JSON(http.StatusOK, gin.H{"level": strings.ToLower(logger.Level.Level().String())})
+	}
+}
+
+// Pprof serves the net/http/pprof profiles under the route's *profile parameter, e.g. /debug/pprof/heap.
+func Pprof() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		switch ctx.Param("profile") {
+		case "/cmdline":
+			pprof.Cmdline(ctx.Writer, ctx.Request)
+		case "/profile":
+			pprof.Profile(ctx.Writer, ctx.Request)
+		case "/symbol":
+			pprof.Symbol(ctx.Writer, ctx.Request)
+		case "/trace":
+			pprof.Trace(ctx.Writer, ctx.Request)
+		default:
+			// Index lists the profiles and serves the named ones, such as heap or goroutine
+			pprof.Index(ctx.Writer, ctx.Request)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/haiyen11231/eco-taxi-api-gateway/internal/apikey"
+	"github.com/haiyen11231/eco-taxi-api-gateway/internal/logger"
+	"github.com/haiyen11231/eco-taxi-api-gateway/internal/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// apiKeyResponse is a key as shown to operators, without the hashes of its secrets
+type apiKeyResponse struct {
+	ID                string     `json:"id"`
+	Owner             string     `json:"owner"`
+	AccountID         uint64     `json:"account_id,omitempty"`
+	Scopes            []string   `json:"scopes"`
+	RateLimit         int        `json:"rate_limit"`
+	CreatedAt         time.Time  `json:"created_at"`
+	ExpiresAt         *time.Time `json:"expires_at,omitempty"`
+	RevokedAt         *time.Time `json:"revoked_at,omitempty"`
+	PreviousExpiresAt *time.Time `json:"previous_expires_at,omitempty"`
+	// APIKey is the key itself, only returned when it is created or rotated
+	APIKey string `json:"api_key,omitempty"`
+}
+
+func newAPIKeyResponse(key apikey.Key, secret string) apiKeyResponse {
+	return apiKeyResponse{
+		ID:                key.ID,
+		Owner:             key.Owner,
+		AccountID:         key.AccountID,
+		Scopes:            key.Scopes,
+		RateLimit:         key.RateLimit,
+		CreatedAt:         key.CreatedAt,
+		ExpiresAt:         key.ExpiresAt,
+		RevokedAt:         key.RevokedAt,
+		PreviousExpiresAt: key.PreviousExpiresAt,
+		APIKey:            secret,
+	}
+}
+
+// CreateAPIKey issues an API key to a partner, acting for the partner's account in the user service. The key
+// is only shown in this response.
+func CreateAPIKey(keys *apikey.Keys) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		var request struct {
+			Owner     string     `json:"owner" binding:"required"`
+			AccountID uint64     `json:"account_id" binding:"required"`
+			Scopes    []string   `json:"scopes"`
+			RateLimit int        `json:"rate_limit" binding:"min=0"`
+			ExpiresAt *time.Time `json:"expires_at"`
+		}
+
+		if err := ctx.ShouldBindJSON(&request); err != nil {
+			utils.ResponseBindError(ctx, err, "Invalid request format")
+			return
+		}
+
+		if request.ExpiresAt != nil && !request.ExpiresAt.After(time.Now()) {
+			utils.ResponseProblem(ctx, utils.Problem{
+				Status:        http.StatusBadRequest,
+				Detail:        "Invalid request format",
+				InvalidParams: []utils.InvalidParam{{Name: "expires_at", Reason: "must be in the future"}},
+			})
+			return
+		}
+
+		key, secret, err := keys.Create(ctx.Request.Context(), apikey.NewKey{
+			Owner:     request.Owner,
+			AccountID: request.AccountID,
+			Scopes:    request.Scopes,
+			RateLimit: request.RateLimit,
+			ExpiresAt: request.ExpiresAt,
+		})
+		if err != nil {
+			logger.FromContext(ctx).Error("Failed to create API key", "error", err)
+			utils.ResponseError(ctx, http.StatusInternalServerError, "Failed to create API key")
+			return
+		}
+
+		logger.FromContext(ctx).Warn("API key created", "api_key_id", key.ID, "api_key_owner", key.Owner)
+		ctx.JSON(http.StatusCreated, newAPIKeyResponse(key, secret))
+	}
+}
+
+// ListAPIKeys responds with every API key, revoked ones included.
+func ListAPIKeys(keys *apikey.Keys) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		list, err := keys.List(ctx.Request.Context())
+		if err != nil {
+			logger.FromContext(ctx).Error("Failed to list API keys", "error", err)
+			utils.ResponseError(ctx, http.StatusInternalServerError, "Failed to list API keys")
+			return
+		}
+
+		response := make([]apiKeyResponse, 0, len(list))
+		for _, key := range list {
+			response = append(response, newAPIKeyResponse(key, ""))
+		}
+		ctx.JSON(http.StatusOK, response)
+	}
+}
+
+// RotateAPIKey gives an API key a new secret. The old one keeps working for the optional grace period,
+// e.g. {"grace": "24h"}, so the partner can switch over without failed requests.
+func RotateAPIKey(keys *apikey.Keys) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		var request struct {
+			Grace string `json:"grace"`
+		}
+
+		if ctx.Request.ContentLength != 0 {
+			if err := ctx.ShouldBindJSON(&request); err != nil {
+				utils.ResponseBindError(ctx, err, "Invalid request format")
+				return
+			}
+		}
+
+		var grace time.Duration
+		if request.Grace != "" {
+			var err error
+			if grace, err = time.ParseDuration(request.Grace); err != nil || grace < 0 {
+				utils.ResponseProblem(ctx, utils.Problem{
+					Status:        http.StatusBadRequest,
+					Detail:        "Invalid request format",
+					InvalidParams: []utils.InvalidParam{{Name: "grace", Reason: "must be a duration such as 24h"}},
+				})
+				return
+			}
+		}
+
+		key, secret, err := keys.Rotate(ctx.Request.Context(), ctx.Param("id"), grace)
+		if err != nil {
+			apiKeyError(ctx, err, "Failed to rotate API key")
+			return
+		}
+
+		logger.FromContext(ctx).Warn("API key rotated", "api_key_id", key.ID, "grace", grace.String())
+		ctx.JSON(http.StatusOK, newAPIKeyResponse(key, secret))
+	}
+}
+
+// RevokeAPIKey stops an API key from working.
+func RevokeAPIKey(keys *apikey.Keys) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		key, err := keys.Revoke(ctx.Request.Context(), ctx.Param("id"))
+		if err != nil {
+			apiKeyError(ctx, err, "Failed to revoke API key")
+			return
+		}
+
+		logger.FromContext(ctx).Warn("API key revoked", "api_key_id", key.ID)
+		ctx.JSON(http.StatusOK, newAPIKeyResponse(key, ""))
+	}
+}
+
+func apiKeyError(ctx *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, apikey.ErrNotFound):
+		utils.ResponseError(ctx, http.StatusNotFound, "API key not found")
+	case errors.Is(err, apikey.ErrInvalidKey):
+		utils.ResponseError(ctx, http.StatusConflict, "API key is revoked or expired")
+	default:
+		logger.FromContext(ctx).Error(message, "error", err)
+		utils.ResponseError(ctx, http.StatusInternalServerError, message)
+	}
+}
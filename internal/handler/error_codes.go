@@ -0,0 +1,28 @@
+package handler
+
+// Stable codes of failed backend calls, one per operation, so clients can branch on them even when the
+// backend doesn't say more. A backend overrides them by attaching a google.rpc.ErrorInfo, and unavailable
+// or timed out backends keep the gateway's backend.* codes.
+const (
+	CodeTripPreviewFailed        = "trip.preview_failed"
+	CodeTripConfirmFailed        = "trip.confirm_failed"
+	CodeTripCurrentBookingFailed = "trip.current_booking_failed"
+	CodeTripStatusUpdateFailed   = "trip.status_update_failed"
+	CodeTripHistoryFailed        = "trip.history_failed"
+
+	CodePaymentCardsFetchFailed = "payment.cards_fetch_failed"
+	CodePaymentCardCreateFailed = "payment.card_create_failed"
+	CodePaymentCardUpdateFailed = "payment.card_update_failed"
+	CodePaymentCardDeleteFailed = "payment.card_delete_failed"
+
+	CodeUserSignUpFailed         = "user.signup_failed"
+	CodeUserLogInFailed          = "user.login_failed"
+	CodeUserLogOutFailed         = "user.logout_failed"
+	CodeUserPasswordResetFailed  = "user.password_reset_failed"
+	CodeUserUpdateFailed         = "user.update_failed"
+	CodeUserFetchFailed          = "user.fetch_failed"
+	CodeUserPasswordChangeFailed = "user.password_change_failed"
+	CodeUserDistanceUpdateFailed = "user.distance_update_failed"
+	CodeUserAuthenticateFailed   = "user.authenticate_failed"
+	CodeUserRefreshFailed        = "user.refresh_failed"
+)
@@ -0,0 +1,51 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/haiyen11231/eco-taxi-api-gateway/internal/backend"
+	"github.com/haiyen11231/eco-taxi-api-gateway/internal/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Liveness reports that the gateway process is running. It never touches the backends,
+// so an orchestrator does not restart the gateway because a downstream service is down.
+func Liveness() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		utils.ResponseSuccess(ctx, http.StatusOK, gin.H{"status": "ok"})
+	}
+}
+
+// Readiness checks the health of every backend service and responds with 503 Service Unavailable
+// when a critical one is not serving, so traffic is no longer routed to this gateway instance.
+// It also fails once draining is set, while the gateway shuts down.
+func Readiness(backends *backend.Registry, timeout time.Duration, draining *atomic.Bool) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if draining.Load() {
+			ctx.JSON(http.StatusServiceUnavailable, gin.H{"status": "draining"})
+			return
+		}
+
+		c, cancel := context.WithTimeout(ctx.Request.Context(), timeout)
+		defer cancel()
+
+		dependencies := backends.CheckHealth(c)
+
+		code, state := http.StatusOK, "ok"
+		for _, dependency := range dependencies {
+			if dependency.Critical && dependency.Status == backend.StatusDown {
+				code, state = http.StatusServiceUnavailable, "unavailable"
+				break
+			}
+		}
+
+		ctx.JSON(code, gin.H{
+			"status":       state,
+			"dependencies": dependencies,
+		})
+	}
+}
@@ -1,59 +1,43 @@
 package handler
 
 import (
-	"context"
 	"encoding/json"
-	"log"
 	"net/http"
-	"os"
 	"strconv"
-	"time"
 
+	"github.com/haiyen11231/eco-taxi-api-gateway/internal/backend"
 	"github.com/haiyen11231/eco-taxi-api-gateway/internal/grpc/pb"
+	"github.com/haiyen11231/eco-taxi-api-gateway/internal/logger"
 	"github.com/haiyen11231/eco-taxi-api-gateway/internal/model"
 	"github.com/haiyen11231/eco-taxi-api-gateway/internal/utils"
 
 	"github.com/gin-gonic/gin"
 	"google.golang.org/protobuf/encoding/protojson"
 )
-  
-func GetCards() gin.HandlerFunc {
-    return func(ctx *gin.Context) {
+
+func GetCards(client pb.PaymentServiceClient) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
 		// Retrieving the user_id from the context, set previously in middleware
-        userId := ctx.GetUint64("user_id")
-    
-        // Establishing a gRPC connection
-        conn, err := utils.GRPCClient(os.Getenv("GRPC_PAYMENT_HOST"))
-        if err != nil {
-            log.Println("Failed to dial", err)
-            utils.ResponseError(ctx, http.StatusBadRequest, err.Error())
-            return
-        }
-        defer conn.Close()
-    
-		// Creating a new PaymentService gRPC client from the connection
-        client := pb.NewPaymentServiceClient(conn)
-
-		// Setting a timeout for the gRPC request to avoid long-running calls
-        c, cancel := context.WithTimeout(context.Background(), time.Second)
-        defer cancel()
-    
-        // Sending a GetCardsRequest to the gRPC service for getting cards
-        response, err := client.GetCards(c, &pb.GetCardsRequest{
-            UserId: userId,
-        })
-
-        // If getting cards fails, logs the error and returns a 400 Bad Request error. On success, it sends a success response with http.StatusAccepted.
-        if err != nil {
-            log.Println("Failed to get cards", err)
-            utils.ResponseError(ctx, http.StatusBadRequest, err.Error())
-            return
-        }
-    
+		userId := ctx.GetUint64("user_id")
+
+		c := ctx.Request.Context()
+
+		// Sending a GetCardsRequest to the gRPC service for getting cards
+		response, err := client.GetCards(c, &pb.GetCardsRequest{
+			UserId: userId,
+		})
+
+		// If getting cards fails, logs the error and returns a 400 Bad Request error. On success, it sends a success response with http.StatusAccepted.
+		if err != nil {
+			logger.FromContext(ctx).Error("Failed to get cards", "error", err)
+			utils.ResponseBackendError(ctx, err, http.StatusBadRequest, CodePaymentCardsFetchFailed, "Fetching the cards failed")
+			return
+		}
+
 		// Marshalling the gRPC response into JSON format
 		b, err := protojson.Marshal(response)
 		if err != nil {
-			log.Println("Failed to marshal response", err)
+			logger.FromContext(ctx).Error("Failed to marshal response", "error", err)
 			utils.ResponseError(ctx, http.StatusBadRequest, err.Error())
 			return
 		}
@@ -68,58 +52,45 @@ func GetCards() gin.HandlerFunc {
 		}
 
 		utils.ResponseSuccess(ctx, http.StatusAccepted, cards["result"])
-    }
+	}
 }
 
-func CreateCard() gin.HandlerFunc {
+func CreateCard(client pb.PaymentServiceClient) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		createCard := model.CreateCardData{}
 		userId := ctx.GetUint64("user_id")
 
-        // Binding the incoming request to create card
+		// Binding the incoming request to create card
 		if err := ctx.ShouldBindJSON(&createCard); err != nil {
-			log.Println("Failed to bind json", err)
-			utils.ResponseError(ctx, http.StatusBadRequest, err.Error())
+			logger.FromContext(ctx).Warn("Failed to bind json", "error", err)
+			utils.ResponseBindError(ctx, err, err.Error())
 			return
 		}
 
 		// Convert CVV to uint64
-        cvv, err := strconv.ParseUint(createCard.Cvv, 10, 64)
-        if err != nil {
-            log.Println("Invalid CVV format:", err)
-            utils.ResponseError(ctx, http.StatusBadRequest, "Invalid CVV format")
-            return
-        }
-
-		// Establishing a gRPC connection
-        conn, err := utils.GRPCClient(os.Getenv("GRPC_PAYMENT_HOST"))
-        if err != nil {
-            log.Println("Failed to dial", err)
-            utils.ResponseError(ctx, http.StatusBadRequest, err.Error())
-            return
-        }
-        defer conn.Close()
-    
-        client := pb.NewPaymentServiceClient(conn)
-        c, cancel := context.WithTimeout(context.Background(), time.Second)
-        defer cancel()
-
-
-        // Sending a GetCardsRequest to the gRPC service for getting cards
-		log.Println("Request: ", userId, createCard)
+		cvv, err := strconv.ParseUint(createCard.Cvv, 10, 64)
+		if err != nil {
+			logger.FromContext(ctx).Warn("Invalid CVV format", "error", err)
+			utils.ResponseError(ctx, http.StatusBadRequest, "Invalid CVV format")
+			return
+		}
+
+		c := backend.WithIdempotencyKey(ctx.Request.Context(), ctx.GetHeader("Idempotency-Key"))
+
+		// Sending a GetCardsRequest to the gRPC service for getting cards
 		response, err := client.CreateCard(c, &pb.CreateCardRequest{
-			UserId: userId,
+			UserId:     userId,
 			CardNumber: createCard.CardNumber,
-            CardHolder: createCard.CardHolder,
-            ExpiryDate: createCard.ExpiryDate,
-            Cvv: cvv,
-            IsDefault: createCard.IsDefault,
+			CardHolder: createCard.CardHolder,
+			ExpiryDate: createCard.ExpiryDate,
+			Cvv:        cvv,
+			IsDefault:  createCard.IsDefault,
 		})
 
-        // If creating card fails, logs the error and returns a 400 Bad Request error. On success, it sends a success response with http.StatusAccepted.
+		// If creating card fails, logs the error and returns a 400 Bad Request error. On success, it sends a success response with http.StatusAccepted.
 		if err != nil {
-			log.Println("Failed to create card", err)
-			utils.ResponseError(ctx, http.StatusBadRequest, err.Error())
+			logger.FromContext(ctx).Error("Failed to create card", "error", err)
+			utils.ResponseBackendError(ctx, err, http.StatusBadRequest, CodePaymentCardCreateFailed, "Card creation failed")
 			return
 		}
 
@@ -127,75 +98,62 @@ func CreateCard() gin.HandlerFunc {
 	}
 }
 
-func UpdateCard() gin.HandlerFunc {
+func UpdateCard(client pb.PaymentServiceClient) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		// Retrieving the card ID from the URL path parameters and converting it to an integer.
 		idStr := ctx.Param("id")
-		log.Println("id: ", idStr)
-        
+
 		id, err := strconv.Atoi(idStr)
 		if err != nil {
-			log.Println("Failed to convert path param id", err)
+			logger.FromContext(ctx).Warn("Failed to convert path param id", "error", err)
 			utils.ResponseError(ctx, http.StatusBadRequest, "Invalid ID")
 			return
 		}
 
 		// idStr := ctx.Query("id")
 		// log.Println("id: ", idStr)
-        // id, err := strconv.Atoi(idStr)
-        // if err != nil {
-        //     log.Println("Failed to convert query param id", err)
-        //     utils.ResponseError(ctx, http.StatusBadRequest, "Invalid ID")
-        //     return
-        // }
+		// id, err := strconv.Atoi(idStr)
+		// if err != nil {
+		//     log.Println("Failed to convert query param id", err)
+		//     utils.ResponseError(ctx, http.StatusBadRequest, "Invalid ID")
+		//     return
+		// }
 
 		updateCard := model.UpdateCardData{}
 		userId := ctx.GetUint64("user_id")
 
-        // Binding the incoming request to update card
+		// Binding the incoming request to update card
 		if err := ctx.ShouldBindJSON(&updateCard); err != nil {
-			log.Println("Failed to bind json", err)
-			utils.ResponseError(ctx, http.StatusBadRequest, err.Error())
+			logger.FromContext(ctx).Warn("Failed to bind json", "error", err)
+			utils.ResponseBindError(ctx, err, err.Error())
 			return
 		}
 
 		// Convert CVV to uint64
-        cvv, err := strconv.ParseUint(updateCard.Cvv, 10, 64)
-        if err != nil {
-            log.Println("Invalid CVV format:", err)
-            utils.ResponseError(ctx, http.StatusBadRequest, "Invalid CVV format")
-            return
-        }
-
-		// Establishing a gRPC connection
-        conn, err := utils.GRPCClient(os.Getenv("GRPC_PAYMENT_HOST"))
-        if err != nil {
-            log.Println("Failed to dial", err)
-            utils.ResponseError(ctx, http.StatusBadRequest, err.Error())
-            return
-        }
-        defer conn.Close()
-    
-        client := pb.NewPaymentServiceClient(conn)
-        c, cancel := context.WithTimeout(context.Background(), time.Second)
-        defer cancel()
-
-        // Sending a UpdateCardRequest to the gRPC service for updating card
-		log.Println("Request: ", userId, id, updateCard)
+		cvv, err := strconv.ParseUint(updateCard.Cvv, 10, 64)
+		if err != nil {
+			logger.FromContext(ctx).Warn("Invalid CVV format", "error", err)
+			utils.ResponseError(ctx, http.StatusBadRequest, "Invalid CVV format")
+			return
+		}
+
+		c := backend.WithIdempotencyKey(ctx.Request.Context(), ctx.GetHeader("Idempotency-Key"))
+
+		// Sending a UpdateCardRequest to the gRPC service for updating card
 		response, err := client.UpdateCard(c, &pb.UpdateCardRequest{
-			Id:          uint64(id),
+			Id:         uint64(id),
 			CardNumber: updateCard.CardNumber,
-            CardHolder: updateCard.CardHolder,
-            ExpiryDate: updateCard.ExpiryDate,
-            Cvv: cvv,
-            IsDefault: updateCard.IsDefault,
-			UserId:      userId,
+			CardHolder: updateCard.CardHolder,
+			ExpiryDate: updateCard.ExpiryDate,
+			Cvv:        cvv,
+			IsDefault:  updateCard.IsDefault,
+			UserId:     userId,
 		})
 
-        // If updating card fails, logs the error and returns a 400 Bad Request error. On success, it sends a success response with http.StatusAccepted.
+		// If updating card fails, logs the error and returns a 400 Bad Request error. On success, it sends a success response with http.StatusAccepted.
 		if err != nil {
-			log.Println("Failed to update card", err)
-			utils.ResponseError(ctx, http.StatusBadRequest, err.Error())
+			logger.FromContext(ctx).Error("Failed to update card", "error", err)
+			utils.ResponseBackendError(ctx, err, http.StatusBadRequest, CodePaymentCardUpdateFailed, "Card update failed")
 			return
 		}
 
@@ -203,43 +161,31 @@ func UpdateCard() gin.HandlerFunc {
 	}
 }
 
-func DeleteCard() gin.HandlerFunc {
+func DeleteCard(client pb.PaymentServiceClient) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		// Retrieving the card ID from the URL path parameters and converting it to an integer.
 		idStr := ctx.Param("id")
-		log.Println("id: ", idStr)
-        
+
 		id, err := strconv.Atoi(idStr)
 		if err != nil {
-			log.Println("Failed to convert path param id", err)
+			logger.FromContext(ctx).Warn("Failed to convert path param id", "error", err)
 			utils.ResponseError(ctx, http.StatusBadRequest, "Invalid ID")
 			return
 		}
 
 		// idStr := ctx.Query("id")
-        // id, err := strconv.Atoi(idStr)
-        // if err != nil {
-        //     log.Println("Failed to convert query param id", err)
-        //     utils.ResponseError(ctx, http.StatusBadRequest, "Invalid ID")
-        //     return
-        // }
+		// id, err := strconv.Atoi(idStr)
+		// if err != nil {
+		//     log.Println("Failed to convert query param id", err)
+		//     utils.ResponseError(ctx, http.StatusBadRequest, "Invalid ID")
+		//     return
+		// }
 
 		userId := ctx.GetUint64("user_id")
 
-		// Establishing a gRPC connection.
-        conn, err := utils.GRPCClient(os.Getenv("GRPC_PAYMENT_HOST"))
-        if err != nil {
-            log.Println("Failed to dial", err)
-            utils.ResponseError(ctx, http.StatusBadRequest, err.Error())
-            return
-        }
-        defer conn.Close()
-    
-        client := pb.NewPaymentServiceClient(conn)
-        c, cancel := context.WithTimeout(context.Background(), time.Second)
-        defer cancel()
-
-        // Sending a DeleteCardRequest to the gRPC service for deleting card
+		c := backend.WithIdempotencyKey(ctx.Request.Context(), ctx.GetHeader("Idempotency-Key"))
+
+		// Sending a DeleteCardRequest to the gRPC service for deleting card
 		response, err := client.DeleteCard(c, &pb.DeleteCardRequest{
 			Id:     uint64(id),
 			UserId: userId,
@@ -247,11 +193,11 @@ func DeleteCard() gin.HandlerFunc {
 
 		// If deleting card fails, logs the error and returns a 400 Bad Request error. On success, it sends a success response with http.StatusAccepted.
 		if err != nil {
-			log.Println("Failed to delete card", err)
-			utils.ResponseError(ctx, http.StatusBadRequest, err.Error())
+			logger.FromContext(ctx).Error("Failed to delete card", "error", err)
+			utils.ResponseBackendError(ctx, err, http.StatusBadRequest, CodePaymentCardDeleteFailed, "Card deletion failed")
 			return
 		}
 
 		utils.ResponseSuccess(ctx, http.StatusAccepted, response)
 	}
-}
\ No newline at end of file
+}
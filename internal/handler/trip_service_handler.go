@@ -1,16 +1,14 @@
 package handler
 
 import (
-	"context"
 	"encoding/json"
-	"log"
 	"net/http"
-	"os"
 	"strconv"
 	"strings"
-	"time"
 
+	"github.com/haiyen11231/eco-taxi-api-gateway/internal/backend"
 	"github.com/haiyen11231/eco-taxi-api-gateway/internal/grpc/pb"
+	"github.com/haiyen11231/eco-taxi-api-gateway/internal/logger"
 	"github.com/haiyen11231/eco-taxi-api-gateway/internal/model"
 	"github.com/haiyen11231/eco-taxi-api-gateway/internal/utils"
 
@@ -24,45 +22,29 @@ import (
 // get (single), update, delete -> need id
 // create, delete, update -> need userId
 
-func SearchTripPreview() gin.HandlerFunc {
+func SearchTripPreview(client pb.TripServiceClient) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		searchTripPreview := model.SearchTripPreviewData{}
 
-		log.Println("Request: .....1");
 		// Binding the incoming request to search trip preview
 		if err := ctx.ShouldBindJSON(&searchTripPreview); err != nil {
-			log.Println("Failed to bind json", err)
-			utils.ResponseError(ctx, http.StatusBadRequest, err.Error())
+			logger.FromContext(ctx).Warn("Failed to bind json", "error", err)
+			utils.ResponseBindError(ctx, err, err.Error())
 			return
 		}
 
-		log.Println("Request: .....2");
-		// Establishing a gRPC connection
-		conn, err := utils.GRPCClient(os.Getenv("GRPC_TRIP_HOST"))
-        if err != nil {
-            log.Println("Failed to dial", err)
-            utils.ResponseError(ctx, http.StatusBadRequest, err.Error())
-            return
-        }
-        defer conn.Close()
-		log.Println("Request: .....3", searchTripPreview);
-    
-        client := pb.NewTripServiceClient(conn)
-        c, cancel := context.WithTimeout(context.Background(), time.Second)
-        defer cancel()
-    
-		log.Println("Request: .....", searchTripPreview);
-        // Sending a SearchTripPreviewRequest to the gRPC service for searching trip preview
+		c := ctx.Request.Context()
+
+		// Sending a SearchTripPreviewRequest to the gRPC service for searching trip preview
 		response, err := client.SearchTripPreview(c, &pb.SearchTripPreviewRequest{
-			Pickup: searchTripPreview.Pickup,
+			Pickup:      searchTripPreview.Pickup,
 			Destination: searchTripPreview.Destination,
 		})
 
-
 		// If searching trip preview fails, logs the error and returns a 400 Bad Request error. On success, it sends a success response with http.StatusAccepted.
 		if err != nil {
-			log.Println("Failed to search trip preview", err)
-			utils.ResponseError(ctx, http.StatusBadRequest, err.Error())
+			logger.FromContext(ctx).Error("Failed to search trip preview", "error", err)
+			utils.ResponseBackendError(ctx, err, http.StatusBadRequest, CodeTripPreviewFailed, "Trip preview failed")
 			return
 		}
 
@@ -70,49 +52,40 @@ func SearchTripPreview() gin.HandlerFunc {
 	}
 }
 
-func ConfirmBooking() gin.HandlerFunc {
+func ConfirmBooking(client pb.TripServiceClient) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		confirmBooking := model.ConfirmBookingData{}
-		userId := ctx.GetUint64("user_id")
+		userId, ok := requireUser(ctx)
+		if !ok {
+			return
+		}
 
 		// Binding the incoming request to confirm booking
 		if err := ctx.ShouldBindJSON(&confirmBooking); err != nil {
-			log.Println("Failed to bind json", err)
-			utils.ResponseError(ctx, http.StatusBadRequest, err.Error())
+			logger.FromContext(ctx).Warn("Failed to bind json", "error", err)
+			utils.ResponseBindError(ctx, err, err.Error())
 			return
 		}
-		
-		// Establishing a gRPC connection
-        conn, err := utils.GRPCClient(os.Getenv("GRPC_TRIP_HOST"))
-        if err != nil {
-            log.Println("Failed to dial", err)
-            utils.ResponseError(ctx, http.StatusBadRequest, err.Error())
-            return
-        }
-        defer conn.Close()
-    
-        client := pb.NewTripServiceClient(conn)
-        c, cancel := context.WithTimeout(context.Background(), time.Second)
-        defer cancel()
-    
-        // Sending a ConfirmBookingRequest to the gRPC service for confirming booking
+
+		c := backend.WithIdempotencyKey(ctx.Request.Context(), ctx.GetHeader("Idempotency-Key"))
+
+		// Sending a ConfirmBookingRequest to the gRPC service for confirming booking
 		response, err := client.ConfirmBooking(c, &pb.ConfirmBookingRequest{
-			Pickup: confirmBooking.Pickup,
-			Destination: confirmBooking.Destination,
-			Distance: confirmBooking.Distance,
-			Fare: confirmBooking.Fare,
-			CardNumber: confirmBooking.CardNumber,
+			Pickup:                   confirmBooking.Pickup,
+			Destination:              confirmBooking.Destination,
+			Distance:                 confirmBooking.Distance,
+			Fare:                     confirmBooking.Fare,
+			CardNumber:               confirmBooking.CardNumber,
 			EstimatedArrivalDateTime: confirmBooking.EstimatedArrivalDateTime,
-			EstimatedWaitingTime: confirmBooking.EstimatedWaitingTime,
-			BookingStatus: confirmBooking.BookingStatus,
-			UserId: userId,
+			EstimatedWaitingTime:     confirmBooking.EstimatedWaitingTime,
+			BookingStatus:            confirmBooking.BookingStatus,
+			UserId:                   userId,
 		})
 
-
 		// If confirming booking fails, logs the error and returns a 400 Bad Request error. On success, it sends a success response with http.StatusAccepted.
 		if err != nil {
-			log.Println("Failed to confirm booking", err)
-			utils.ResponseError(ctx, http.StatusBadRequest, err.Error())
+			logger.FromContext(ctx).Error("Failed to confirm booking", "error", err)
+			utils.ResponseBackendError(ctx, err, http.StatusBadRequest, CodeTripConfirmFailed, "Booking failed")
 			return
 		}
 
@@ -120,34 +93,25 @@ func ConfirmBooking() gin.HandlerFunc {
 	}
 }
 
-func GetIncompletedBooking() gin.HandlerFunc {
+func GetIncompletedBooking(client pb.TripServiceClient) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
-		userId := ctx.GetUint64("user_id")
-		
-		// Establishing a gRPC connection
-        conn, err := utils.GRPCClient(os.Getenv("GRPC_TRIP_HOST"))
-        if err != nil {
-            log.Println("Failed to dial", err)
-            utils.ResponseError(ctx, http.StatusBadRequest, err.Error())
-            return
-        }
-        defer conn.Close()
-    
-        client := pb.NewTripServiceClient(conn)
-        c, cancel := context.WithTimeout(context.Background(), time.Second)
-        defer cancel()
-    
-        // Sending a GetIncompletedBookingRequest to the gRPC service for getting incompleted booking.
+		userId, ok := requireUser(ctx)
+		if !ok {
+			return
+		}
+
+		c := ctx.Request.Context()
+
+		// Sending a GetIncompletedBookingRequest to the gRPC service for getting incompleted booking.
 		response, err := client.GetIncompletedBooking(c, &pb.GetIncompletedBookingRequest{
-			UserId: userId,
+			UserId:        userId,
 			BookingStatus: pb.BookingStatus_INCOMPLETED,
 		})
 
-
 		// If getting incompleted booking fails, logs the error and returns a 400 Bad Request error. On success, it sends a success response with http.StatusAccepted.
 		if err != nil {
-			log.Println("Failed to get incompleted booking", err)
-			utils.ResponseError(ctx, http.StatusBadRequest, err.Error())
+			logger.FromContext(ctx).Error("Failed to get incompleted booking", "error", err)
+			utils.ResponseBackendError(ctx, err, http.StatusBadRequest, CodeTripCurrentBookingFailed, "Fetching the current booking failed")
 			return
 		}
 
@@ -155,57 +119,48 @@ func GetIncompletedBooking() gin.HandlerFunc {
 	}
 }
 
-func UpdateBookingStatus() gin.HandlerFunc {
+func UpdateBookingStatus(client pb.TripServiceClient) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		id, err := strconv.Atoi(ctx.Params.ByName("id"))
 		if err != nil {
-			log.Println("Failed to convert params", err)
+			logger.FromContext(ctx).Warn("Failed to convert params", "error", err)
 			utils.ResponseError(ctx, http.StatusBadRequest, err.Error())
 			return
 		}
 
 		updateBookingStatus := model.UpdateBookingStatusData{}
-		userId := ctx.GetUint64("user_id")
+		userId, ok := requireUser(ctx)
+		if !ok {
+			return
+		}
 
 		// Binding the incoming request to update booking status
 		if err := ctx.ShouldBindJSON(&updateBookingStatus); err != nil {
-			log.Println("Failed to bind json", err)
-			utils.ResponseError(ctx, http.StatusBadRequest, err.Error())
+			logger.FromContext(ctx).Warn("Failed to bind json", "error", err)
+			utils.ResponseBindError(ctx, err, err.Error())
 			return
 		}
-		
-		// Establishing a gRPC connection
-        conn, err := utils.GRPCClient(os.Getenv("GRPC_TRIP_HOST"))
-        if err != nil {
-            log.Println("Failed to dial", err)
-            utils.ResponseError(ctx, http.StatusBadRequest, err.Error())
-            return
-        }
-        defer conn.Close()
-    
-        client := pb.NewTripServiceClient(conn)
-        c, cancel := context.WithTimeout(context.Background(), time.Second)
-        defer cancel()
-    
-        // Sending a UpdateBookingRequest to the gRPC service for updating booking status
+
+		c := backend.WithIdempotencyKey(ctx.Request.Context(), ctx.GetHeader("Idempotency-Key"))
+
+		// Sending a UpdateBookingRequest to the gRPC service for updating booking status
 		response, err := client.UpdateBookingStatus(c, &pb.UpdateBookingRequest{
-			Id: uint64(id),
-			Pickup: updateBookingStatus.Pickup,
-			Destination: updateBookingStatus.Destination,
-			Distance: updateBookingStatus.Distance,
-			Fare: updateBookingStatus.Fare,
-			CardNumber: updateBookingStatus.CardNumber,
+			Id:                       uint64(id),
+			Pickup:                   updateBookingStatus.Pickup,
+			Destination:              updateBookingStatus.Destination,
+			Distance:                 updateBookingStatus.Distance,
+			Fare:                     updateBookingStatus.Fare,
+			CardNumber:               updateBookingStatus.CardNumber,
 			EstimatedArrivalDateTime: updateBookingStatus.EstimatedArrivalDateTime,
-			EstimatedWaitingTime: updateBookingStatus.EstimatedWaitingTime,
-			BookingStatus: updateBookingStatus.BookingStatus,
-			UserId: userId,
+			EstimatedWaitingTime:     updateBookingStatus.EstimatedWaitingTime,
+			BookingStatus:            updateBookingStatus.BookingStatus,
+			UserId:                   userId,
 		})
 
-
 		// If updating booking status fails, logs the error and returns a 400 Bad Request error. On success, it sends a success response with http.StatusAccepted.
 		if err != nil {
-			log.Println("Failed to update booking status", err)
-			utils.ResponseError(ctx, http.StatusBadRequest, err.Error())
+			logger.FromContext(ctx).Error("Failed to update booking status", "error", err)
+			utils.ResponseBackendError(ctx, err, http.StatusBadRequest, CodeTripStatusUpdateFailed, "Booking status update failed")
 			return
 		}
 
@@ -213,7 +168,7 @@ func UpdateBookingStatus() gin.HandlerFunc {
 	}
 }
 
-func GetBookingHistory() gin.HandlerFunc {
+func GetBookingHistory(client pb.TripServiceClient) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		// Retrieving query parameters
 		p := ctx.Query("page")
@@ -229,79 +184,69 @@ func GetBookingHistory() gin.HandlerFunc {
 
 		page, err := strconv.Atoi(p)
 		if err != nil {
-			log.Println("Failed to convert query page", err)
+			logger.FromContext(ctx).Warn("Failed to convert query page", "error", err)
 			utils.ResponseError(ctx, http.StatusBadRequest, err.Error())
 			return
 		}
 
 		limit, err := strconv.Atoi(l)
 		if err != nil {
-			log.Println("Failed to convert query limit", err)
+			logger.FromContext(ctx).Warn("Failed to convert query limit", "error", err)
 			utils.ResponseError(ctx, http.StatusBadRequest, err.Error())
 			return
 		}
-		
-		userId := ctx.GetUint64("user_id")
-
-        bookingStatusHeader := ctx.GetHeader("booking-status")
-        orderAscHeader := ctx.GetHeader("order-asc")
-
-        // Default values
-        bookingStatuses := []pb.BookingStatus{pb.BookingStatus_INCOMPLETED, pb.BookingStatus_COMPLETED, pb.BookingStatus_CANCELED} // Default to all booking statuses
-
-        // Parsing headers for booking statuses
-        if bookingStatusHeader != "" {
-            statusArray := strings.Split(bookingStatusHeader, ",")
-            bookingStatuses = bookingStatusesFromStrings(statusArray)
-        }
-
-        // Setting default value for orderAsc
-        orderAsc := true // Default to ascending order
-        if orderAscHeader != "" {
-            orderAsc = orderAscHeader == "true" // Convert to boolean if provided
-        }
-
-		
-		// Establishing a gRPC connection
-        conn, err := utils.GRPCClient(os.Getenv("GRPC_TRIP_HOST"))
-        if err != nil {
-            log.Println("Failed to dial", err)
-            utils.ResponseError(ctx, http.StatusBadRequest, err.Error())
-            return
-        }
-        defer conn.Close()
-    
-        client := pb.NewTripServiceClient(conn)
-        c, cancel := context.WithTimeout(context.Background(), time.Second)
-        defer cancel()
-    
-        // Sending a GetBookingHistoryRequest to the gRPC service for getting booking history
+
+		userId, ok := requireUser(ctx)
+		if !ok {
+			return
+		}
+
+		bookingStatusHeader := ctx.GetHeader("booking-status")
+		orderAscHeader := ctx.GetHeader("order-asc")
+
+		// Default values
+		bookingStatuses := []pb.BookingStatus{pb.BookingStatus_INCOMPLETED, pb.BookingStatus_COMPLETED, pb.BookingStatus_CANCELED} // Default to all booking statuses
+
+		// Parsing headers for booking statuses
+		if bookingStatusHeader != "" {
+			statusArray := strings.Split(bookingStatusHeader, ",")
+			bookingStatuses = bookingStatusesFromStrings(statusArray)
+		}
+
+		// Setting default value for orderAsc
+		orderAsc := true // Default to ascending order
+		if orderAscHeader != "" {
+			orderAsc = orderAscHeader == "true" // Convert to boolean if provided
+		}
+
+		c := ctx.Request.Context()
+
+		// Sending a GetBookingHistoryRequest to the gRPC service for getting booking history
 		response, err := client.GetBookingHistory(c, &pb.GetBookingHistoryRequest{
-			Page: uint64(page),
-			Limit: uint64(limit),
-			UserId: userId,
+			Page:            uint64(page),
+			Limit:           uint64(limit),
+			UserId:          userId,
 			BookingStatuses: bookingStatuses,
-			OrderAsc: orderAsc,
+			OrderAsc:        orderAsc,
 		})
 
-
 		// If getting booking history fails, logs the error and returns a 400 Bad Request error. On success, it sends a success response with http.StatusAccepted.
 		if err != nil {
-			log.Println("Failed to get booking history", err)
-			utils.ResponseError(ctx, http.StatusBadRequest, err.Error())
+			logger.FromContext(ctx).Error("Failed to get booking history", "error", err)
+			utils.ResponseBackendError(ctx, err, http.StatusBadRequest, CodeTripHistoryFailed, "Fetching the booking history failed")
 			return
 		}
 
 		b, err := protojson.Marshal(response)
 		if err != nil {
-			log.Println("Failed to marshal response", err)
+			logger.FromContext(ctx).Error("Failed to marshal response", "error", err)
 			utils.ResponseError(ctx, http.StatusBadRequest, err.Error())
 			return
 		}
 
 		bookings := map[string]any{}
 		json.Unmarshal(b, &bookings)
- 
+
 		if bookings["result"] == nil {
 			bookings["result"] = []interface{}{}
 		}
@@ -310,26 +255,38 @@ func GetBookingHistory() gin.HandlerFunc {
 	}
 }
 
+// requireUser returns the ID of the user the caller acts as. Callers without one, such as API keys that have
+// no account, get a 403 Forbidden error instead of acting on bookings as user 0.
+func requireUser(ctx *gin.Context) (uint64, bool) {
+	userId := ctx.GetUint64("user_id")
+	if userId == 0 {
+		logger.FromContext(ctx).Warn("Caller has no user ID")
+		utils.ResponseError(ctx, http.StatusForbidden, "This caller has no user account to act for")
+		return 0, false
+	}
+	return userId, true
+}
+
 // Function to convert string to BookingStatus enum
 func bookingStatusFromString(statusStr string) pb.BookingStatus {
-    switch statusStr {
-    case "INCOMPLETED":
-        return pb.BookingStatus_INCOMPLETED
-    case "COMPLETED":
-        return pb.BookingStatus_COMPLETED
-    case "CANCELLED":
-        return pb.BookingStatus_CANCELED
-    default:
-        return pb.BookingStatus_COMPLETED
-    }
+	switch statusStr {
+	case "INCOMPLETED":
+		return pb.BookingStatus_INCOMPLETED
+	case "COMPLETED":
+		return pb.BookingStatus_COMPLETED
+	case "CANCELLED":
+		return pb.BookingStatus_CANCELED
+	default:
+		return pb.BookingStatus_COMPLETED
+	}
 }
 
 // Function to convert slice of strings to slice of BookingStatus enums
 func bookingStatusesFromStrings(statuses []string) []pb.BookingStatus {
-    var enumStatuses []pb.BookingStatus
-    for _, status := range statuses {
-        enumStatus := bookingStatusFromString(status)
-        enumStatuses = append(enumStatuses, enumStatus)
-    }
-    return enumStatuses
-}
\ No newline at end of file
+	var enumStatuses []pb.BookingStatus
+	for _, status := range statuses {
+		enumStatus := bookingStatusFromString(status)
+		enumStatuses = append(enumStatuses, enumStatus)
+	}
+	return enumStatuses
+}
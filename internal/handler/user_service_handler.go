@@ -2,53 +2,175 @@ package handler
 
 import (
 	"context"
-	"log"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
 	"net/http"
-	"os"
+	"net/url"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/haiyen11231/eco-taxi-api-gateway/internal/auth"
+	"github.com/haiyen11231/eco-taxi-api-gateway/internal/backend"
 	"github.com/haiyen11231/eco-taxi-api-gateway/internal/grpc/pb"
+	"github.com/haiyen11231/eco-taxi-api-gateway/internal/logger"
+	"github.com/haiyen11231/eco-taxi-api-gateway/internal/loginguard"
 	"github.com/haiyen11231/eco-taxi-api-gateway/internal/model"
+	"github.com/haiyen11231/eco-taxi-api-gateway/internal/notify"
+	"github.com/haiyen11231/eco-taxi-api-gateway/internal/telemetry"
 	"github.com/haiyen11231/eco-taxi-api-gateway/internal/utils"
+	"github.com/haiyen11231/eco-taxi-api-gateway/internal/verification"
 
 	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
-func SignUp() gin.HandlerFunc {
+// phoneVerificationPurpose scopes the codes confirming phone numbers at signup
+const phoneVerificationPurpose = "phone-verification"
+
+// loginSettleTimeout bounds recording a login attempt's outcome once the client may have gone away
+const loginSettleTimeout = 2 * time.Second
+
+// SignUp holds the registration until its phone number is confirmed, and texts a code to the number.
+// VerifyPhone creates the account, so nobody can claim a phone number they don't have.
+func SignUp(verifier *verification.Codes, sms notify.Notifier) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		userData := model.SignUpUserData{}
 
 		// Binding and validating incoming request for signup
 		if err := ctx.ShouldBindJSON(&userData); err != nil {
-			log.Println("Failed to bind JSON for SignUp:", err)
-			utils.ResponseError(ctx, http.StatusBadRequest, "Invalid request format")
+			logger.FromContext(ctx).Warn("Failed to bind JSON for SignUp", "error", err)
+			utils.ResponseBindError(ctx, err, "Invalid request format")
 			return
 		}
 
-		// Establishing a gRPC connection
-		conn, err := utils.GRPCClient(os.Getenv("GRPC_USER_HOST"))
+		c := ctx.Request.Context()
+
+		// Keeping the registration with the code. A pending one for the number is never replaced, or whoever
+		// signed up second would set the password of the account the number's owner confirms.
+		registration, err := json.Marshal(userData)
 		if err != nil {
-			log.Println("Failed to dial gRPC service:", err)
-			utils.ResponseError(ctx, http.StatusInternalServerError, "Service unavailable")
+			logger.FromContext(ctx).Error("Failed to encode registration", "error", err)
+			utils.ResponseError(ctx, http.StatusInternalServerError, "Signup failed")
 			return
 		}
-		defer conn.Close()
 
-		client := pb.NewUserServiceClient(conn)
-		c, cancel := context.WithTimeout(context.Background(), 5*time.Second) 
-		defer cancel()
+		code, wait, err := verifier.IssueOnce(c, phoneVerificationPurpose, normalizePhoneNumber(userData.PhoneNumber), registration)
+		if errors.Is(err, verification.ErrPending) {
+			logger.FromContext(ctx).Warn("Signup refused, another one is pending for the phone number")
+			utils.ResponseError(ctx, http.StatusConflict, "A signup is already pending for this phone number, enter the code sent to it or try again later")
+			return
+		}
+		if err != nil {
+			logger.FromContext(ctx).Error("Failed to issue phone verification code", "error", err)
+			utils.ResponseError(ctx, http.StatusInternalServerError, "Signup failed")
+			return
+		}
+
+		sendPhoneVerificationCode(ctx, verifier, sms, userData.PhoneNumber, code, wait)
+	}
+}
+
+// ResendPhoneCode texts a new code for a pending registration, e.g. when the first one didn't arrive.
+func ResendPhoneCode(verifier *verification.Codes, sms notify.Notifier) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		resendPhoneCodeData := model.ResendPhoneCodeData{}
+
+		// Binding and validating incoming request for resending the code
+		if err := ctx.ShouldBindJSON(&resendPhoneCodeData); err != nil {
+			logger.FromContext(ctx).Warn("Failed to bind JSON for ResendPhoneCode", "error", err)
+			utils.ResponseBindError(ctx, err, "Invalid request format")
+			return
+		}
+
+		phoneNumber := resendPhoneCodeData.PhoneNumber
+		code, wait, err := verifier.Resend(ctx.Request.Context(), phoneVerificationPurpose, normalizePhoneNumber(phoneNumber))
+		if err != nil {
+			if errors.Is(err, verification.ErrInvalidCode) {
+				utils.ResponseError(ctx, http.StatusNotFound, "No pending signup for this phone number")
+				return
+			}
+			logger.FromContext(ctx).Error("Failed to issue phone verification code", "error", err)
+			utils.ResponseError(ctx, http.StatusInternalServerError, "Resending the code failed")
+			return
+		}
+
+		sendPhoneVerificationCode(ctx, verifier, sms, phoneNumber, code, wait)
+	}
+}
+
+// sendPhoneVerificationCode texts a freshly issued code, or refuses with 429 when the previous one is too
+// recent to be replaced
+func sendPhoneVerificationCode(ctx *gin.Context, verifier *verification.Codes, sms notify.Notifier, phoneNumber, code string, wait time.Duration) {
+	if wait > 0 {
+		ctx.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		utils.ResponseError(ctx, http.StatusTooManyRequests, "A code was sent recently, please wait before requesting another")
+		return
+	}
+
+	text := fmt.Sprintf("Your Eco Taxi verification code is %s. It expires in %s.", code, verifier.TTL())
+	if err := sms.Send(ctx.Request.Context(), notify.Message{To: phoneNumber, Text: text}); err != nil {
+		logger.FromContext(ctx).Error("Failed to send phone verification code", "error", err)
+		utils.ResponseError(ctx, http.StatusServiceUnavailable, "The verification code couldn't be sent, please try again later")
+		return
+	}
+
+	utils.ResponseSuccess(ctx, http.StatusAccepted, gin.H{
+		"message":    "A verification code has been sent to the phone number",
+		"expires_in": int(verifier.TTL().Seconds()),
+	})
+}
+
+// VerifyPhone creates the account of a pending registration, given the code texted to its phone number.
+func VerifyPhone(client pb.UserServiceClient, verifier *verification.Codes) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		verifyPhoneData := model.VerifyPhoneData{}
+
+		// Binding and validating incoming request for phone verification
+		if err := ctx.ShouldBindJSON(&verifyPhoneData); err != nil {
+			logger.FromContext(ctx).Warn("Failed to bind JSON for VerifyPhone", "error", err)
+			utils.ResponseBindError(ctx, err, "Invalid request format")
+			return
+		}
+
+		c := ctx.Request.Context()
+
+		// Checking and using up the code; wrong guesses count towards the code's attempt limit
+		registration, err := verifier.Verify(c, phoneVerificationPurpose, normalizePhoneNumber(verifyPhoneData.PhoneNumber), verifyPhoneData.Code)
+		if err != nil {
+			if errors.Is(err, verification.ErrInvalidCode) {
+				logger.FromContext(ctx).Warn("Invalid phone verification code")
+				utils.ResponseError(ctx, http.StatusBadRequest, "Invalid or expired code")
+				return
+			}
+			logger.FromContext(ctx).Error("Failed to check phone verification code", "error", err)
+			utils.ResponseError(ctx, http.StatusInternalServerError, "Phone verification failed")
+			return
+		}
+
+		userData := model.SignUpUserData{}
+		if err := json.Unmarshal(registration, &userData); err != nil {
+			logger.FromContext(ctx).Error("Failed to decode registration", "error", err)
+			utils.ResponseError(ctx, http.StatusInternalServerError, "Phone verification failed")
+			return
+		}
+
+		c = backend.WithIdempotencyKey(c, ctx.GetHeader("Idempotency-Key"))
 
 		// Sending a SignUpRequest with user details to the gRPC service
 		response, err := client.SignUp(c, &pb.SignUpRequest{
 			Name:        userData.Name,
 			PhoneNumber: userData.PhoneNumber,
 			Email:       userData.Email,
-			Password:    userData.Password, 
+			Password:    userData.Password,
 		})
 
 		if err != nil {
-			log.Println("Failed to signup:", err)
-			utils.ResponseError(ctx, http.StatusBadRequest, "Signup failed")
+			logger.FromContext(ctx).Error("Failed to signup", "error", err)
+			utils.ResponseBackendError(ctx, err, http.StatusBadRequest, CodeUserSignUpFailed, "Signup failed")
 			return
 		}
 
@@ -56,66 +178,109 @@ func SignUp() gin.HandlerFunc {
 	}
 }
 
-func LogIn() gin.HandlerFunc {
+// normalizePhoneNumber makes differently spaced forms of a number share their pending registration
+func normalizePhoneNumber(phoneNumber string) string {
+	return strings.Join(strings.Fields(phoneNumber), "")
+}
+
+func LogIn(client pb.UserServiceClient, sessions *auth.Sessions, guard *loginguard.Guard) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		logInUserData := model.LogInUserData{}
 
 		// Binding and validating incoming request for login
 		if err := ctx.ShouldBindJSON(&logInUserData); err != nil {
-			log.Println("Failed to bind JSON for LogIn:", err)
-			utils.ResponseError(ctx, http.StatusBadRequest, "Invalid request format")
+			logger.FromContext(ctx).Warn("Failed to bind JSON for LogIn", "error", err)
+			utils.ResponseBindError(ctx, err, "Invalid request format")
 			return
 		}
 
-		// Establishing a gRPC connection
-		conn, err := utils.GRPCClient(os.Getenv("GRPC_USER_HOST"))
+		c := ctx.Request.Context()
+		phoneNumber, ip := logInUserData.PhoneNumber, ctx.ClientIP()
+
+		// Reserving the attempt, or refusing it while the phone number or client IP is delayed or locked out
+		// after failed logins. If the counts can't be read, logins go ahead rather than locking everyone out.
+		attempt, wait, err := guard.Begin(c, phoneNumber, ip)
 		if err != nil {
-			log.Println("Failed to dial gRPC service:", err)
-			utils.ResponseError(ctx, http.StatusInternalServerError, "Service unavailable")
+			logger.FromContext(ctx).Error("Failed to check login attempts", "error", err)
+		}
+		if wait > 0 {
+			logger.FromContext(ctx).Warn("Login refused after failed attempts", "retry_after", wait.String())
+			telemetry.LoginAttempts.WithLabelValues(telemetry.LoginLocked).Inc()
+			ctx.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			utils.ResponseError(ctx, http.StatusTooManyRequests, "Too many failed login attempts, try again later")
 			return
 		}
-		defer conn.Close()
-
-		client := pb.NewUserServiceClient(conn)
-		c, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
 
 		// Sending a LogInRequest to the gRPC service for login
 		response, err := client.LogIn(c, &pb.LogInRequest{
-			PhoneNumber: logInUserData.PhoneNumber,
-			Password:    logInUserData.Password, 
+			PhoneNumber: phoneNumber,
+			Password:    logInUserData.Password,
 		})
 
+		// Recording the outcome even when the request is cancelled, so a failure still counts and the
+		// reservation isn't left held
+		settle, cancel := context.WithTimeout(context.WithoutCancel(c), loginSettleTimeout)
+		defer cancel()
+
 		if err != nil {
-			log.Println("Failed to login:", err)
-			utils.ResponseError(ctx, http.StatusUnauthorized, "Invalid credentials")
+			logger.FromContext(ctx).Error("Failed to login", "error", err)
+
+			// Counting rejected credentials only, not the user service being down. Every rejection gets the same
+			// answer, so it doesn't tell whether the phone number has an account.
+			if loginguard.IsFailedLogin(err) {
+				telemetry.LoginAttempts.WithLabelValues(telemetry.AuthFailure).Inc()
+				locked, err := attempt.Failed(settle)
+				if err != nil {
+					logger.FromContext(ctx).Error("Failed to record failed login", "error", err)
+				}
+				if locked {
+					logger.FromContext(ctx).Warn("Login locked out after repeated failures")
+				}
+
+				utils.ResponseProblem(ctx, utils.Problem{Status: http.StatusUnauthorized, Detail: "Invalid credentials", Code: CodeUserLogInFailed})
+				return
+			}
+
+			telemetry.LoginAttempts.WithLabelValues(telemetry.AuthError).Inc()
+			if err := attempt.Release(settle); err != nil {
+				logger.FromContext(ctx).Error("Failed to release login attempt", "error", err)
+			}
+			utils.ResponseBackendError(ctx, err, http.StatusUnauthorized, CodeUserLogInFailed, "Invalid credentials")
 			return
 		}
 
-		// Setting secure cookie with attributes
-		ctx.SetSameSite(http.SameSiteLaxMode)
-		ctx.SetCookie("Authorization", response.RefreshToken, 3600*24, "/", "", true, true)
+		telemetry.LoginAttempts.WithLabelValues(telemetry.AuthSuccess).Inc()
+		if err := attempt.Succeeded(settle); err != nil {
+			logger.FromContext(ctx).Error("Failed to reset failed logins", "error", err)
+		}
 
-		utils.ResponseSuccess(ctx, http.StatusAccepted, response)
+		// Replacing the user service's refresh token with one the gateway rotates on every refresh
+		refreshToken, err := sessions.Start(c, response.Id, response.RefreshToken)
+		if err != nil {
+			logger.FromContext(ctx).Error("Failed to start session", "error", err)
+			utils.ResponseError(ctx, http.StatusInternalServerError, "Login failed")
+			return
+		}
+
+		// Setting secure cookie with attributes, keeping the refresh token out of the body
+		if sessions.CookieMode() {
+			http.SetCookie(ctx.Writer, sessions.Cookie(refreshToken))
+			refreshToken = ""
+		}
+
+		utils.ResponseSuccess(ctx, http.StatusAccepted, &pb.LogInResponse{
+			Id:           response.Id,
+			AccessToken:  response.AccessToken,
+			RefreshToken: refreshToken,
+		})
 	}
 }
 
-func LogOut() gin.HandlerFunc {
+func LogOut(client pb.UserServiceClient, sessions *auth.Sessions, invalidator auth.UserInvalidator) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		userId := ctx.GetUint64("user_id")
 
-		// Establishing a gRPC connection
-		conn, err := utils.GRPCClient(os.Getenv("GRPC_USER_HOST"))
-		if err != nil {
-			log.Println("Failed to dial gRPC service:", err)
-			utils.ResponseError(ctx, http.StatusInternalServerError, "Service unavailable")
-			return
-		}
-		defer conn.Close()
-
-		client := pb.NewUserServiceClient(conn)
-		c, cancel := context.WithTimeout(context.Background(), 5*time.Second) 
-		defer cancel()
+		c := backend.WithIdempotencyKey(ctx.Request.Context(), ctx.GetHeader("Idempotency-Key"))
 
 		// Sending a LogOutRequest to the gRPC service for logout
 		response, err := client.LogOut(c, &pb.LogOutRequest{
@@ -123,61 +288,134 @@ func LogOut() gin.HandlerFunc {
 		})
 
 		if err != nil {
-			log.Println("Failed to logout:", err)
-			utils.ResponseError(ctx, http.StatusBadRequest, "Logout failed")
+			logger.FromContext(ctx).Error("Failed to logout", "error", err)
+			utils.ResponseBackendError(ctx, err, http.StatusBadRequest, CodeUserLogOutFailed, "Logout failed")
 			return
-			}
+		}
 
-		
-		// Invalidate the session by clearing the cookie
-		ctx.SetCookie("Authorization", "", -1, "/", "", true, true)
+		// Invalidate the session by revoking the refresh tokens, clearing the cookie and forgetting the cached token checks
+		if err := invalidator.InvalidateUser(c, userId); err != nil {
+			logger.FromContext(ctx).Error("Failed to revoke sessions", "error", err)
+		}
+		if sessions.CookieMode() {
+			http.SetCookie(ctx.Writer, sessions.ClearCookie())
+		}
 		utils.ResponseSuccess(ctx, http.StatusOK, "Logged out successfully")
 
 		utils.ResponseSuccess(ctx, http.StatusOK, response)
 	}
 }
 
-func ForgotPassword() gin.HandlerFunc {
+// passwordResetPurpose scopes password reset codes, keeping them apart from other verification codes
+const passwordResetPurpose = "password-reset"
+
+// RequestPasswordReset sends a one-time code confirming a password reset to the email address. It answers
+// the same whether or not an account has the address, so it can't be used to find out which ones do.
+func RequestPasswordReset(verifier *verification.Codes, notifier notify.Notifier, linkURL string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		requestPasswordResetData := model.RequestPasswordResetData{}
+
+		// Binding and validating incoming request for password reset
+		if err := ctx.ShouldBindJSON(&requestPasswordResetData); err != nil {
+			logger.FromContext(ctx).Warn("Failed to bind JSON for RequestPasswordReset", "error", err)
+			utils.ResponseBindError(ctx, err, "Invalid request format")
+			return
+		}
+
+		c := ctx.Request.Context()
+		email := normalizeEmail(requestPasswordResetData.Email)
+
+		// Issuing a code without asking the user service whether the account exists, so neither the
+		// response nor its timing depends on it
+		code, wait, err := verifier.Issue(c, passwordResetPurpose, email, nil)
+		switch {
+		case err != nil:
+			logger.FromContext(ctx).Error("Failed to issue password reset code", "error", err)
+		case wait > 0:
+			logger.FromContext(ctx).Info("Password reset requested again too soon", "retry_after", wait.String())
+		default:
+			text := fmt.Sprintf("Your Eco Taxi password reset code is %s. It expires in %s.", code, verifier.TTL())
+			if linkURL != "" {
+				query := url.Values{"email": {email}, "code": {code}}
+				text += " Or reset your password at " + linkURL + "?" + query.Encode()
+			}
+
+			if err := notifier.Send(c, notify.Message{To: email, Subject: "Reset your password", Text: text}); err != nil {
+				logger.FromContext(ctx).Error("Failed to send password reset code", "error", err)
+			}
+		}
+
+		utils.ResponseSuccess(ctx, http.StatusAccepted, gin.H{
+			"message": "If an account uses this email address, a reset code has been sent to it",
+		})
+	}
+}
+
+// ConfirmPasswordReset sets a new password for the account of the email address, given the code sent to it,
+// and ends the account's sessions, which may be held by whoever made the reset necessary.
+func ConfirmPasswordReset(client pb.UserServiceClient, verifier *verification.Codes, invalidator auth.UserInvalidator) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
-		forgotPasswordUserData := model.ForgotPasswordUserData{}
+		confirmPasswordResetData := model.ConfirmPasswordResetData{}
 
 		// Binding and validating incoming request for password reset
-		if err := ctx.ShouldBindJSON(&forgotPasswordUserData); err != nil {
-			log.Println("Failed to bind JSON for ForgotPassword:", err)
-			utils.ResponseError(ctx, http.StatusBadRequest, "Invalid request format")
+		if err := ctx.ShouldBindJSON(&confirmPasswordResetData); err != nil {
+			logger.FromContext(ctx).Warn("Failed to bind JSON for ConfirmPasswordReset", "error", err)
+			utils.ResponseBindError(ctx, err, "Invalid request format")
 			return
 		}
 
-		// Establishing a gRPC connection
-		conn, err := utils.GRPCClient(os.Getenv("GRPC_USER_HOST"))
-		if err != nil {
-			log.Println("Failed to dial gRPC service:", err)
-			utils.ResponseError(ctx, http.StatusInternalServerError, "Service unavailable")
+		c := ctx.Request.Context()
+		email := normalizeEmail(confirmPasswordResetData.Email)
+
+		// Checking and using up the code; wrong guesses count towards the code's attempt limit
+		if _, err := verifier.Verify(c, passwordResetPurpose, email, confirmPasswordResetData.Code); err != nil {
+			if errors.Is(err, verification.ErrInvalidCode) {
+				logger.FromContext(ctx).Warn("Invalid password reset code")
+				utils.ResponseError(ctx, http.StatusBadRequest, "Invalid or expired code")
+				return
+			}
+			logger.FromContext(ctx).Error("Failed to check password reset code", "error", err)
+			utils.ResponseError(ctx, http.StatusInternalServerError, "Password reset failed")
 			return
 		}
-		defer conn.Close()
 
-		client := pb.NewUserServiceClient(conn)
-		c, cancel := context.WithTimeout(context.Background(), 5*time.Second) 
-		defer cancel()
+		c = backend.WithIdempotencyKey(c, ctx.GetHeader("Idempotency-Key"))
 
 		// Sending a ForgotPasswordRequest to the gRPC service for resetting password
 		response, err := client.ForgotPassword(c, &pb.ForgotPasswordRequest{
-			Email:       forgotPasswordUserData.Email,
-			NewPassword: forgotPasswordUserData.NewPassword, // Ensure this is hashed before sending
+			Email:       email,
+			NewPassword: confirmPasswordResetData.NewPassword, // Ensure this is hashed before sending
 		})
 
 		if err != nil {
-			log.Println("Failed to reset password:", err)
-			utils.ResponseError(ctx, http.StatusBadRequest, "Password reset failed")
+			logger.FromContext(ctx).Error("Failed to reset password", "error", err)
+
+			// Answering an unknown account like a wrong code, so the code doesn't confirm the account exists
+			if status.Code(err) == codes.NotFound {
+				utils.ResponseError(ctx, http.StatusBadRequest, "Invalid or expired code")
+				return
+			}
+			utils.ResponseBackendError(ctx, err, http.StatusBadRequest, CodeUserPasswordResetFailed, "Password reset failed")
 			return
 		}
 
-		utils.ResponseSuccess(ctx, http.StatusAccepted, response)
+		// Revoking the account's refresh tokens and forgetting its cached token checks, like a password change
+		if response.Id == 0 {
+			logger.FromContext(ctx).Error("Password reset response has no user ID, sessions were not revoked")
+		} else if err := invalidator.InvalidateUser(c, response.Id); err != nil {
+			logger.FromContext(ctx).Error("Failed to revoke sessions", "error", err)
+		}
+
+		utils.ResponseSuccess(ctx, http.StatusAccepted, &pb.ForgotPasswordResponse{Message: response.Message})
 	}
 }
 
-func UpdateUser() gin.HandlerFunc {
+// normalizeEmail makes differently typed forms of an address share their reset codes
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+func UpdateUser(client pb.UserServiceClient) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		// Retrieving the user_id from the context, set previously in middleware
 		userId := ctx.GetUint64("user_id")
@@ -186,35 +424,43 @@ func UpdateUser() gin.HandlerFunc {
 
 		// Binding and validating incoming request for user update
 		if err := ctx.ShouldBindJSON(&userData); err != nil {
-			log.Println("Failed to bind JSON for UpdateUser:", err)
-			utils.ResponseError(ctx, http.StatusBadRequest, "Invalid request format")
+			logger.FromContext(ctx).Warn("Failed to bind JSON for UpdateUser", "error", err)
+			utils.ResponseBindError(ctx, err, "Invalid request format")
 			return
 		}
 
-		// Establishing a gRPC connection
-		conn, err := utils.GRPCClient(os.Getenv("GRPC_USER_HOST"))
+		c := ctx.Request.Context()
+
+		// Refusing phone number changes, since logins are by phone number and a new one hasn't been verified
+		current, err := client.GetUser(c, &pb.GetUserRequest{Id: userId})
 		if err != nil {
-			log.Println("Failed to dial gRPC service:", err)
-			utils.ResponseError(ctx, http.StatusInternalServerError, "Service unavailable")
+			logger.FromContext(ctx).Error("Failed to get user for update", "error", err)
+			utils.ResponseBackendError(ctx, err, http.StatusBadRequest, CodeUserUpdateFailed, "User update failed")
+			return
+		}
+		if normalizePhoneNumber(userData.PhoneNumber) != normalizePhoneNumber(current.PhoneNumber) {
+			logger.FromContext(ctx).Warn("Refused unverified phone number change")
+			utils.ResponseProblem(ctx, utils.Problem{
+				Status:        http.StatusBadRequest,
+				Detail:        "The phone number can't be changed without verifying the new one",
+				InvalidParams: []utils.InvalidParam{{Name: "phone_number", Reason: "must be the account's verified phone number"}},
+			})
 			return
 		}
-		defer conn.Close()
 
-		client := pb.NewUserServiceClient(conn)
-		c, cancel := context.WithTimeout(context.Background(), 5*time.Second) 
-		defer cancel()
+		c = backend.WithIdempotencyKey(c, ctx.GetHeader("Idempotency-Key"))
 
 		// Sending a UpdateUserRequest with user details to the gRPC service for updating user
 		response, err := client.UpdateUser(c, &pb.UpdateUserRequest{
 			Id:          userId,
 			Name:        userData.Name,
-			PhoneNumber: userData.PhoneNumber,
+			PhoneNumber: current.PhoneNumber,
 			Email:       userData.Email,
 		})
 
 		if err != nil {
-			log.Println("Failed to update user:", err)
-			utils.ResponseError(ctx, http.StatusBadRequest, "User update failed")
+			logger.FromContext(ctx).Error("Failed to update user", "error", err)
+			utils.ResponseBackendError(ctx, err, http.StatusBadRequest, CodeUserUpdateFailed, "User update failed")
 			return
 		}
 
@@ -222,22 +468,11 @@ func UpdateUser() gin.HandlerFunc {
 	}
 }
 
-func GetUser() gin.HandlerFunc {
+func GetUser(client pb.UserServiceClient) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		userId := ctx.GetUint64("user_id")
 
-		// Establishing a gRPC connection
-		conn, err := utils.GRPCClient(os.Getenv("GRPC_USER_HOST"))
-		if err != nil {
-			log.Println("Failed to dial gRPC service:", err)
-			utils.ResponseError(ctx, http.StatusInternalServerError, "Service unavailable")
-			return
-		}
-		defer conn.Close()
-
-		client := pb.NewUserServiceClient(conn)
-		c, cancel := context.WithTimeout(context.Background(), 5*time.Second) 
-		defer cancel()
+		c := ctx.Request.Context()
 
 		// Sending a GetUserRequest to the gRPC service for getting user
 		response, err := client.GetUser(c, &pb.GetUserRequest{
@@ -245,16 +480,16 @@ func GetUser() gin.HandlerFunc {
 		})
 
 		if err != nil {
-			log.Println("Failed to get user:", err)
-			utils.ResponseError(ctx, http.StatusBadRequest, "User fetch failed")
+			logger.FromContext(ctx).Error("Failed to get user", "error", err)
+			utils.ResponseBackendError(ctx, err, http.StatusBadRequest, CodeUserFetchFailed, "User fetch failed")
 			return
-			}
+		}
 
 		utils.ResponseSuccess(ctx, http.StatusOK, response)
 	}
 }
 
-func ChangePassword() gin.HandlerFunc {
+func ChangePassword(client pb.UserServiceClient, invalidator auth.UserInvalidator) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		// Retrieving the user_id from the context, set previously in middleware
 		userId := ctx.GetUint64("user_id")
@@ -263,23 +498,12 @@ func ChangePassword() gin.HandlerFunc {
 
 		// Binding the incoming request to change password
 		if err := ctx.ShouldBindJSON(&changePasswordUserData); err != nil {
-			log.Println("Failed to bind JSON:", err)
-			utils.ResponseError(ctx, http.StatusBadRequest, "Invalid request data")
-			return
-		}
-
-		// Establishing a gRPC connection
-		conn, err := utils.GRPCClient(os.Getenv("GRPC_USER_HOST"))
-		if err != nil {
-			log.Println("Failed to dial gRPC server:", err)
-			utils.ResponseError(ctx, http.StatusInternalServerError, "Internal server error")
+			logger.FromContext(ctx).Warn("Failed to bind JSON", "error", err)
+			utils.ResponseBindError(ctx, err, "Invalid request data")
 			return
 		}
-		defer conn.Close()
 
-		client := pb.NewUserServiceClient(conn)
-		c, cancel := context.WithTimeout(context.Background(), time.Second)
-		defer cancel()
+		c := backend.WithIdempotencyKey(ctx.Request.Context(), ctx.GetHeader("Idempotency-Key"))
 
 		// Sending a ChangePasswordRequest with user details to the gRPC service for changing password
 		response, err := client.ChangePassword(c, &pb.ChangePasswordRequest{
@@ -290,16 +514,22 @@ func ChangePassword() gin.HandlerFunc {
 
 		// Check for error in changing password
 		if err != nil {
-			log.Println("Failed to change password:", err)
-			utils.ResponseError(ctx, http.StatusBadRequest, "Failed to change password")
+			logger.FromContext(ctx).Error("Failed to change password", "error", err)
+			utils.ResponseBackendError(ctx, err, http.StatusBadRequest, CodeUserPasswordChangeFailed, "Failed to change password")
 			return
 		}
 
+		// Revoking the user's refresh tokens, and making their access tokens be checked again, as the user
+		// service may have revoked them
+		if err := invalidator.InvalidateUser(c, userId); err != nil {
+			logger.FromContext(ctx).Error("Failed to revoke sessions", "error", err)
+		}
+
 		utils.ResponseSuccess(ctx, http.StatusAccepted, response)
 	}
 }
 
-func UpdateDistanceTravelled() gin.HandlerFunc {
+func UpdateDistanceTravelled(client pb.UserServiceClient) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		// Retrieving the user_id from the context, set previously in middleware
 		userId := ctx.GetUint64("user_id")
@@ -308,23 +538,12 @@ func UpdateDistanceTravelled() gin.HandlerFunc {
 
 		// Binding the incoming request to update distance travelled
 		if err := ctx.ShouldBindJSON(&updateDistanceUserData); err != nil {
-			log.Println("Failed to bind JSON:", err)
-			utils.ResponseError(ctx, http.StatusBadRequest, "Invalid request data")
-			return
-		}
-
-		// Establishing a gRPC connection
-		conn, err := utils.GRPCClient(os.Getenv("GRPC_USER_HOST"))
-		if err != nil {
-			log.Println("Failed to dial gRPC server:", err)
-			utils.ResponseError(ctx, http.StatusInternalServerError, "Internal server error")
+			logger.FromContext(ctx).Warn("Failed to bind JSON", "error", err)
+			utils.ResponseBindError(ctx, err, "Invalid request data")
 			return
 		}
-		defer conn.Close()
 
-		client := pb.NewUserServiceClient(conn)
-		c, cancel := context.WithTimeout(context.Background(), time.Second)
-		defer cancel()
+		c := backend.WithIdempotencyKey(ctx.Request.Context(), ctx.GetHeader("Idempotency-Key"))
 
 		// Sending an UpdateDistanceTravelledRequest to the gRPC service
 		response, err := client.UpdateDistanceTravelled(c, &pb.UpdateDistanceTravelledRequest{
@@ -334,8 +553,8 @@ func UpdateDistanceTravelled() gin.HandlerFunc {
 
 		// Check for error in updating distance travelled
 		if err != nil {
-			log.Println("Failed to update distance travelled:", err)
-			utils.ResponseError(ctx, http.StatusBadRequest, "Failed to update distance travelled")
+			logger.FromContext(ctx).Error("Failed to update distance travelled", "error", err)
+			utils.ResponseBackendError(ctx, err, http.StatusBadRequest, CodeUserDistanceUpdateFailed, "Failed to update distance travelled")
 			return
 		}
 
@@ -343,29 +562,18 @@ func UpdateDistanceTravelled() gin.HandlerFunc {
 	}
 }
 
-func AuthenticateUser() gin.HandlerFunc {
+func AuthenticateUser(client pb.UserServiceClient) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		authenticateUserData := model.AuthenticateUserData{}
 
 		// Binding the incoming request to verify user
 		if err := ctx.ShouldBindJSON(&authenticateUserData); err != nil {
-			log.Println("Failed to bind JSON:", err)
-			utils.ResponseError(ctx, http.StatusBadRequest, "Invalid request data")
-			return
-		}
-
-		// Establishing a gRPC connection
-		conn, err := utils.GRPCClient(os.Getenv("GRPC_USER_HOST"))
-		if err != nil {
-			log.Println("Failed to dial gRPC server:", err)
-			utils.ResponseError(ctx, http.StatusInternalServerError, "Internal server error")
+			logger.FromContext(ctx).Warn("Failed to bind JSON", "error", err)
+			utils.ResponseBindError(ctx, err, "Invalid request data")
 			return
 		}
-		defer conn.Close()
 
-		client := pb.NewUserServiceClient(conn)
-		c, cancel := context.WithTimeout(context.Background(), time.Second)
-		defer cancel()
+		c := ctx.Request.Context()
 
 		// Sending an AuthenticateUserRequest with user token to the gRPC service for authentication
 		response, err := client.AuthenticateUser(c, &pb.AuthenticateUserRequest{
@@ -374,8 +582,8 @@ func AuthenticateUser() gin.HandlerFunc {
 
 		// Check for error in authentication
 		if err != nil {
-			log.Println("Failed to authenticate user:", err)
-			utils.ResponseError(ctx, http.StatusUnauthorized, "Authentication failed")
+			logger.FromContext(ctx).Error("Failed to authenticate user", "error", err)
+			utils.ResponseBackendError(ctx, err, http.StatusUnauthorized, CodeUserAuthenticateFailed, "Authentication failed")
 			return
 		}
 
@@ -383,41 +591,88 @@ func AuthenticateUser() gin.HandlerFunc {
 	}
 }
 
-func RefreshToken() gin.HandlerFunc {
+func RefreshToken(client pb.UserServiceClient, sessions *auth.Sessions) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
-		// Extracting the refresh token from the cookie
-		refreshToken, err := ctx.Cookie("Authorization")
-		if err != nil {
-			log.Println("Refresh token required")
+		// Extracting the refresh token from the cookie, or from the body when cookies aren't used
+		var refreshToken string
+		if sessions.CookieMode() {
+			refreshToken, _ = ctx.Cookie(auth.RefreshCookieName)
+		} else {
+			refreshTokenData := model.RefreshTokenData{}
+			if err := ctx.ShouldBindJSON(&refreshTokenData); err != nil {
+				logger.FromContext(ctx).Warn("Failed to bind JSON for RefreshToken", "error", err)
+				utils.ResponseBindError(ctx, err, "Invalid request format")
+				return
+			}
+			refreshToken = refreshTokenData.RefreshToken
+		}
+
+		if refreshToken == "" {
+			logger.FromContext(ctx).Warn("Refresh token required")
 			utils.ResponseError(ctx, http.StatusUnauthorized, "Unauthorized!")
 			return
 		}
 
-		// Establishing a gRPC connection
-		conn, err := utils.GRPCClient(os.Getenv("GRPC_USER_HOST"))
+		c := ctx.Request.Context()
+
+		session, err := sessions.Lookup(c, refreshToken)
 		if err != nil {
-			log.Println("Failed to dial gRPC service:", err)
-			utils.ResponseError(ctx, http.StatusInternalServerError, "Service unavailable")
+			refreshFailed(ctx, sessions, err)
 			return
 		}
-		defer conn.Close()
-
-		client := pb.NewUserServiceClient(conn)
-		c, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
 
-		// Sending a RefreshTokenRequest to the gRPC service
+		// Sending a RefreshTokenRequest with the user service's refresh token to the gRPC service
 		response, err := client.RefreshToken(c, &pb.RefreshTokenRequest{
-			RefreshToken: refreshToken,
+			RefreshToken: session.BackendToken,
 		})
 
 		if err != nil {
-			log.Println("Failed to refresh token:", err)
-			utils.ResponseError(ctx, http.StatusUnauthorized, "Invalid refresh token")
+			logger.FromContext(ctx).Error("Failed to refresh token", "error", err)
+			utils.ResponseBackendError(ctx, err, http.StatusUnauthorized, CodeUserRefreshFailed, "Invalid refresh token")
+			return
+		}
+
+		// Rotating the refresh token only once the user service accepted it, so a failed call can be retried
+		refreshToken, err = sessions.Rotate(c, refreshToken)
+		if err != nil {
+			refreshFailed(ctx, sessions, err)
 			return
 		}
 
+		if sessions.CookieMode() {
+			http.SetCookie(ctx.Writer, sessions.Cookie(refreshToken))
+			refreshToken = ""
+		}
+
 		// Respond with new access token
-		utils.ResponseSuccess(ctx, http.StatusOK, response)
+		utils.ResponseSuccess(ctx, http.StatusOK, model.RefreshTokenResult{
+			AccessToken:  response.AccessToken,
+			RefreshToken: refreshToken,
+		})
 	}
-}
\ No newline at end of file
+}
+
+// refreshFailed rejects a refresh token that is unknown, expired or was already rotated
+func refreshFailed(ctx *gin.Context, sessions *auth.Sessions, err error) {
+	switch {
+	case !errors.Is(err, auth.ErrInvalidToken) && !errors.Is(err, auth.ErrRefreshTokenRotated) && !errors.Is(err, auth.ErrRefreshTokenReused):
+		// Keeping the cookie, as the token may well be valid once the session store is reachable again
+		logger.FromContext(ctx).Error("Failed to check refresh token", "error", err)
+		utils.ResponseError(ctx, http.StatusServiceUnavailable, "Token refresh is unavailable, please try again later")
+		return
+	case errors.Is(err, auth.ErrRefreshTokenRotated):
+		// Keeping the cookie, which a concurrent refresh has just replaced with a valid one
+		logger.FromContext(ctx).Info("Refresh token was just rotated by another request", "error", err)
+		utils.ResponseError(ctx, http.StatusUnauthorized, "Refresh token already rotated")
+		return
+	case errors.Is(err, auth.ErrRefreshTokenReused):
+		logger.FromContext(ctx).Warn("Rotated refresh token presented again, revoked its session", "error", err)
+	default:
+		logger.FromContext(ctx).Warn("Invalid refresh token", "error", err)
+	}
+
+	if sessions.CookieMode() {
+		http.SetCookie(ctx.Writer, sessions.ClearCookie())
+	}
+	utils.ResponseError(ctx, http.StatusUnauthorized, "Invalid refresh token")
+}
@@ -0,0 +1,219 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/haiyen11231/eco-taxi-api-gateway/internal/auth"
+	"github.com/haiyen11231/eco-taxi-api-gateway/internal/grpc/pb"
+	"github.com/haiyen11231/eco-taxi-api-gateway/internal/loginguard"
+	"github.com/haiyen11231/eco-taxi-api-gateway/internal/notify"
+	"github.com/haiyen11231/eco-taxi-api-gateway/internal/redistest"
+	"github.com/haiyen11231/eco-taxi-api-gateway/internal/verification"
+
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// userService answers the calls a test sets, and fails it on any other
+type userService struct {
+	pb.UserServiceClient
+	t *testing.T
+
+	signUp func(*pb.SignUpRequest) (*pb.SignUpResponse, error)
+	logIn  func(*pb.LogInRequest) (*pb.LogInResponse, error)
+}
+
+func (s userService) SignUp(_ context.Context, in *pb.SignUpRequest, _ ...grpc.CallOption) (*pb.SignUpResponse, error) {
+	if s.signUp == nil {
+		s.t.Fatal("unexpected SignUp call")
+	}
+	return s.signUp(in)
+}
+
+func (s userService) LogIn(_ context.Context, in *pb.LogInRequest, _ ...grpc.CallOption) (*pb.LogInResponse, error) {
+	if s.logIn == nil {
+		s.t.Fatal("unexpected LogIn call")
+	}
+	return s.logIn(in)
+}
+
+// outbox keeps the messages sent to users
+type outbox struct {
+	mu       sync.Mutex
+	messages []notify.Message
+}
+
+func (o *outbox) Send(_ context.Context, message notify.Message) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.messages = append(o.messages, message)
+	return nil
+}
+
+var codePattern = regexp.MustCompile(`\b\d{6}\b`)
+
+// lastCode returns the code in the latest message
+func (o *outbox) lastCode(t *testing.T) string {
+	t.Helper()
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if len(o.messages) == 0 {
+		t.Fatal("no message sent")
+	}
+	return codePattern.FindString(o.messages[len(o.messages)-1].Text)
+}
+
+// serve makes a JSON request to the router and returns the recorded response
+func serve(r http.Handler, method, path, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestPhoneCodeResendKeepsGuessesUsed(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	verifier, err := verification.New(verification.Config{
+		TTL: time.Minute, MaxAttempts: 3, MaxCodes: 5, Window: time.Hour, ResendInterval: time.Millisecond,
+	}, verification.NewMemoryStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sms := &outbox{}
+
+	r := gin.New()
+	r.POST("/signup", SignUp(verifier, sms))
+	r.POST("/verify-phone", VerifyPhone(userService{t: t}, verifier))
+	r.POST("/verify-phone/resend", ResendPhoneCode(verifier, sms))
+
+	w := serve(r, http.MethodPost, "/signup", `{"name":"Rider","phone_number":"+84900000001","email":"rider@example.com","password":"secret"}`)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("signup: %d %s", w.Code, w.Body)
+	}
+
+	// Using up the guesses, then asking for new codes
+	for i := 0; i < 3; i++ {
+		code := wrongCode(sms.lastCode(t))
+		if w := serve(r, http.MethodPost, "/verify-phone", `{"phone_number":"+84900000001","code":"`+code+`"}`); w.Code != http.StatusBadRequest {
+			t.Fatalf("wrong guess %d: %d %s", i, w.Code, w.Body)
+		}
+
+		time.Sleep(2 * time.Millisecond)
+		serve(r, http.MethodPost, "/verify-phone/resend", `{"phone_number":"+84900000001"}`)
+	}
+
+	// The right code, texted after the guesses ran out, is refused without reaching the user service
+	time.Sleep(2 * time.Millisecond)
+	serve(r, http.MethodPost, "/signup", `{"name":"Rider","phone_number":"+84900000001","email":"rider@example.com","password":"secret"}`)
+	code := sms.lastCode(t)
+	if w := serve(r, http.MethodPost, "/verify-phone", `{"phone_number":"+84900000001","code":"`+code+`"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("right code after the guesses ran out: %d %s, want 400", w.Code, w.Body)
+	}
+}
+
+// wrongCode returns a code other than code
+func wrongCode(code string) string {
+	if code == "000000" {
+		return "000001"
+	}
+	return "000000"
+}
+
+func TestLogInRejectionsLookAlike(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	users := userService{t: t, logIn: func(in *pb.LogInRequest) (*pb.LogInResponse, error) {
+		if in.PhoneNumber == "+84900000001" {
+			return nil, status.Error(codes.Unauthenticated, "wrong password")
+		}
+		return nil, status.Error(codes.NotFound, "user not found")
+	}}
+	sessions := auth.NewSessions(auth.SessionConfig{Transport: auth.TransportCookie, Lifetime: time.Hour}, auth.NewMemorySessionStore())
+	guard := loginguard.New(loginguard.Config{
+		MaxFailures: 10, MaxFailuresPerIP: 10, DelayAfter: 10,
+		BaseDelay: time.Second, MaxDelay: time.Second, Window: time.Hour, Lockout: time.Hour,
+	}, loginguard.NewMemoryStore())
+
+	r := gin.New()
+	r.POST("/login", LogIn(users, sessions, guard))
+
+	// Without the request-specific instance, a wrong password and an unknown number get the same answer
+	answer := func(phoneNumber string) (int, map[string]any) {
+		w := serve(r, http.MethodPost, "/login", `{"phone_number":"`+phoneNumber+`","password":"guess"}`)
+		var body map[string]any
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatal(err)
+		}
+		delete(body, "instance")
+		return w.Code, body
+	}
+	wrongStatus, wrongBody := answer("+84900000001")
+	unknownStatus, unknownBody := answer("+84900000002")
+
+	if wrongStatus != http.StatusUnauthorized || unknownStatus != wrongStatus || !reflect.DeepEqual(wrongBody, unknownBody) {
+		t.Errorf("wrong password got %d %v, unknown number got %d %v", wrongStatus, wrongBody, unknownStatus, unknownBody)
+	}
+	if wrongBody["detail"] != "Invalid credentials" {
+		t.Errorf("detail = %v, want Invalid credentials", wrongBody["detail"])
+	}
+}
+
+func TestLogInSettlesCancelledRequest(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus int
+		retryAfter string
+	}{
+		// The failure still counts, locking the phone number out rather than leaving its attempt reserved
+		{"rejected", status.Error(codes.Unauthenticated, "wrong password"), http.StatusTooManyRequests, "3600"},
+		// The reservation is given back, so the phone number isn't refused for an attempt that didn't count
+		{"unavailable", status.Error(codes.Unavailable, "connection refused"), http.StatusServiceUnavailable, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The client goes away while the user service checks the password
+			c, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			users := userService{t: t, logIn: func(*pb.LogInRequest) (*pb.LogInResponse, error) {
+				cancel()
+				return nil, tt.err
+			}}
+			sessions := auth.NewSessions(auth.SessionConfig{Transport: auth.TransportCookie, Lifetime: time.Hour}, auth.NewMemorySessionStore())
+			guard := loginguard.New(loginguard.Config{
+				MaxFailures: 1, MaxFailuresPerIP: 10, DelayAfter: 10,
+				BaseDelay: time.Second, MaxDelay: time.Second, Window: time.Hour, Lockout: time.Hour,
+			}, loginguard.NewRedisStore(redistest.NewClient(t), "test:"))
+
+			r := gin.New()
+			r.POST("/login", LogIn(users, sessions, guard))
+
+			body := `{"phone_number":"+84900000001","password":"guess"}`
+			req := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(body)).WithContext(c)
+			req.Header.Set("Content-Type", "application/json")
+			r.ServeHTTP(httptest.NewRecorder(), req)
+
+			w := serve(r, http.MethodPost, "/login", body)
+			if w.Code != tt.wantStatus || w.Header().Get("Retry-After") != tt.retryAfter {
+				t.Errorf("next login got %d with Retry-After %q, want %d with %q",
+					w.Code, w.Header().Get("Retry-After"), tt.wantStatus, tt.retryAfter)
+			}
+		})
+	}
+}
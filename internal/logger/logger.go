@@ -0,0 +1,60 @@
+package logger
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// Level is the minimum level of the gateway logger. It can be changed at runtime.
+var Level = new(slog.LevelVar)
+
+// New returns a JSON logger writing to w. Every attribute, including the message, passes through the
+// redaction layer before it is written.
+func New(w io.Writer) *slog.Logger {
+	return slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{
+		Level:       Level,
+		ReplaceAttr: redactAttr,
+	}))
+}
+
+// Setup installs the JSON logger as the default logger at the given level ("debug", "info", "warn" or "error").
+// Output of the standard log package is routed through it as well.
+func Setup(w io.Writer, level string) error {
+	if err := SetLevel(level); err != nil {
+		return err
+	}
+	slog.SetDefault(New(w))
+	return nil
+}
+
+// SetLevel changes the minimum level of the gateway logger. An empty level selects info.
+func SetLevel(level string) error {
+	if level == "" {
+		level = "info"
+	}
+
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(strings.ToLower(level))); err != nil {
+		return fmt.Errorf("invalid log level %q", level)
+	}
+	Level.Set(l)
+	return nil
+}
+
+type contextKey struct{}
+
+// WithContext returns a copy of ctx carrying the request-scoped logger l.
+func WithContext(ctx context.Context, l *slog.Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, l)
+}
+
+// FromContext returns the request-scoped logger, or the default logger outside of a request.
+func FromContext(ctx context.Context) *slog.Logger {
+	if l, ok := ctx.Value(contextKey{}).(*slog.Logger); ok {
+		return l
+	}
+	return slog.Default()
+}
@@ -0,0 +1,69 @@
+package logger
+
+import (
+	"fmt"
+	"log/slog"
+	"regexp"
+)
+
+const redacted = "[REDACTED]"
+
+// sensitiveKey matches attribute keys whose values are never written, e.g. "password", "new_password",
+// "card_number", "cvv", "refresh_token" or "authorization".
+var sensitiveKey = regexp.MustCompile(`(?i)(password|card_?number|cvv|token|secret|authorization|cookie)`)
+
+// exemptKeys hold gateway-generated identifiers that would otherwise look like phone or card numbers.
+var exemptKeys = map[string]bool{
+	"request_id": true,
+	"trace_id":   true,
+	"span_id":    true,
+	"user_id":    true,
+}
+
+// valuePatterns mask personal data inside free text such as messages and backend errors.
+// Card numbers are matched before phone numbers, which would otherwise match part of them.
+var valuePatterns = []struct {
+	pattern     *regexp.Regexp
+	replacement string
+}{
+	// key=value or key: value pairs in formatted structs and error strings
+	{regexp.MustCompile(`(?i)\b(\w*(?:password|card_?number|cvv|token|secret)\w*)(["']?\s*[:=]\s*["']?)[^\s,"'}\]]+`), "${1}${2}" + redacted},
+	{regexp.MustCompile(`(?i)\bbearer\s+[\w.~+/-]+=*`), "Bearer " + redacted},
+	{regexp.MustCompile(`\beyJ[\w-]*\.[\w-]*\.[\w-]*`), "[REDACTED_TOKEN]"},
+	{regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`), "[REDACTED_EMAIL]"},
+	{regexp.MustCompile(`\b(?:\d[ -]?){12,18}\d\b`), "[REDACTED_CARD]"},
+	{regexp.MustCompile(`\+?\(?\d[\d ()-]{6,}\d`), "[REDACTED_PHONE]"},
+}
+
+// Redact masks passwords, card numbers, tokens, phone numbers and email addresses in s.
+func Redact(s string) string {
+	for _, p := range valuePatterns {
+		s = p.pattern.ReplaceAllString(s, p.replacement)
+	}
+	return s
+}
+
+// redactAttr is the ReplaceAttr hook of the gateway logger.
+func redactAttr(groups []string, a slog.Attr) slog.Attr {
+	if a.Key == slog.TimeKey || a.Key == slog.LevelKey || exemptKeys[a.Key] {
+		return a
+	}
+
+	if sensitiveKey.MatchString(a.Key) {
+		return slog.String(a.Key, redacted)
+	}
+
+	switch a.Value.Kind() {
+	case slog.KindString:
+		return slog.String(a.Key, Redact(a.Value.String()))
+	case slog.KindAny:
+		// Errors and arbitrary values are flattened to text so that nested fields are masked as well
+		value := a.Value.Any()
+		if err, ok := value.(error); ok {
+			return slog.String(a.Key, Redact(err.Error()))
+		}
+		return slog.String(a.Key, Redact(fmt.Sprintf("%+v", value)))
+	}
+
+	return a
+}
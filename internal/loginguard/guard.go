@@ -0,0 +1,174 @@
+package loginguard
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Config sets how failed logins are throttled. Failures are counted per phone number and per client IP
+// over Window; a phone number or IP is refused for a growing delay after DelayAfter failures, and locked
+// out after its maximum.
+type Config struct {
+	// MaxFailures locks a phone number out, MaxFailuresPerIP a client IP, which may be trying many numbers.
+	MaxFailures      int
+	MaxFailuresPerIP int
+	// DelayAfter is the number of failures allowed without delay. Each further failure doubles the delay,
+	// starting at BaseDelay and capped at MaxDelay.
+	DelayAfter int
+	BaseDelay  time.Duration
+	MaxDelay   time.Duration
+	// Window is how long a failure is counted; the count starts over once it passes without failures.
+	Window time.Duration
+	// Lockout is how long a phone number or IP is refused once it reaches its maximum.
+	Lockout time.Duration
+}
+
+// Guard throttles failed logins.
+type Guard struct {
+	cfg   Config
+	store Store
+}
+
+// New returns a guard keeping its counts in store.
+func New(cfg Config, store Store) *Guard {
+	return &Guard{cfg: cfg, store: store}
+}
+
+// Attempt is a login attempt holding its place in the counts of its phone number and client IP. Exactly
+// one of Failed, Succeeded or Release must be called once its outcome is known.
+type Attempt struct {
+	guard       *Guard
+	phoneNumber string
+	reserved    []reservation
+}
+
+type reservation struct {
+	key   key
+	count int
+}
+
+// Begin reserves a login attempt before the credentials are checked, so concurrent attempts can't exceed
+// the limits between a check and the failures it records. It returns how long the attempt must wait
+// instead, or 0 if it may go ahead. On error the attempt goes ahead uncounted.
+func (g *Guard) Begin(ctx context.Context, phoneNumber, ip string) (*Attempt, time.Duration, error) {
+	a := &Attempt{guard: g, phoneNumber: phoneNumber}
+
+	var wait time.Duration
+	for _, key := range g.keys(phoneNumber, ip) {
+		d, err := g.store.LockedFor(ctx, key.name)
+		if err != nil {
+			return a, 0, err
+		}
+		wait = max(wait, d)
+	}
+	if wait > 0 {
+		return a, wait, nil
+	}
+
+	for _, key := range g.keys(phoneNumber, ip) {
+		count, err := g.store.Reserve(ctx, key.name, g.cfg.Window)
+		if err != nil {
+			return a, 0, errors.Join(err, a.Release(ctx))
+		}
+		a.reserved = append(a.reserved, reservation{key: key, count: count})
+
+		// Attempts still in progress have taken the remaining ones; they lock the key out if they fail
+		if count > key.max {
+			return a, g.cfg.BaseDelay, a.Release(ctx)
+		}
+	}
+	return a, 0, nil
+}
+
+// Failed records the attempt as failed, delaying or locking out the phone number and IP as their failures
+// add up. It reports whether either got locked out.
+func (a *Attempt) Failed(ctx context.Context) (bool, error) {
+	g, locked := a.guard, false
+	for _, r := range a.reserved {
+		var d time.Duration
+		switch {
+		case r.count >= r.key.max:
+			d = g.cfg.Lockout
+			locked = true
+		case r.count > g.cfg.DelayAfter:
+			d = g.delay(r.count - g.cfg.DelayAfter)
+		default:
+			continue
+		}
+
+		if err := g.store.Lock(ctx, r.key.name, d); err != nil {
+			return false, err
+		}
+	}
+	a.reserved = nil
+	return locked, nil
+}
+
+// Succeeded forgets the phone number's failures. The IP's are kept, only this attempt's reservation is
+// released, so an attacker can't clear them by logging into an account of their own between guesses.
+func (a *Attempt) Succeeded(ctx context.Context) error {
+	for _, r := range a.reserved {
+		if r.key.name != phoneKey(a.phoneNumber) {
+			if err := a.guard.store.Release(ctx, r.key.name); err != nil {
+				return err
+			}
+		}
+	}
+	a.reserved = nil
+	return a.guard.store.Reset(ctx, phoneKey(a.phoneNumber))
+}
+
+// Release gives back the attempt's reservations without counting it, when the credentials couldn't be
+// checked, e.g. because the user service is unavailable.
+func (a *Attempt) Release(ctx context.Context) error {
+	var errs []error
+	for _, r := range a.reserved {
+		errs = append(errs, a.guard.store.Release(ctx, r.key.name))
+	}
+	a.reserved = nil
+	return errors.Join(errs...)
+}
+
+// delay returns the delay after the nth failure past DelayAfter
+func (g *Guard) delay(n int) time.Duration {
+	d := g.cfg.BaseDelay
+	for i := 1; i < n && d < g.cfg.MaxDelay; i++ {
+		d *= 2
+	}
+	return min(d, g.cfg.MaxDelay)
+}
+
+type key struct {
+	name string
+	max  int
+}
+
+func (g *Guard) keys(phoneNumber, ip string) []key {
+	return []key{
+		{name: phoneKey(phoneNumber), max: g.cfg.MaxFailures},
+		{name: "ip:" + ip, max: g.cfg.MaxFailuresPerIP},
+	}
+}
+
+func phoneKey(phoneNumber string) string {
+	return "phone:" + strings.Join(strings.Fields(phoneNumber), "")
+}
+
+// IsFailedLogin reports whether a LogIn error means the credentials were rejected, rather than the user
+// service failing or being unreachable, which mustn't count against the user.
+func IsFailedLogin(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.Canceled, codes.ResourceExhausted, codes.Internal:
+		return false
+	}
+	return true
+}
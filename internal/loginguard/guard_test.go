@@ -0,0 +1,152 @@
+package loginguard
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/haiyen11231/eco-taxi-api-gateway/internal/redistest"
+)
+
+// stores returns the guard's counts in memory and in Redis, both without any failures yet
+func stores(t *testing.T) map[string]Store {
+	return map[string]Store{
+		"memory": NewMemoryStore(),
+		"redis":  NewRedisStore(redistest.NewClient(t), "test:login:"),
+	}
+}
+
+func testConfig() Config {
+	return Config{
+		MaxFailures:      3,
+		MaxFailuresPerIP: 10,
+		DelayAfter:       10,
+		BaseDelay:        time.Second,
+		MaxDelay:         time.Minute,
+		Window:           time.Hour,
+		Lockout:          time.Hour,
+	}
+}
+
+func TestGuardConcurrentAttemptsStayWithinLimit(t *testing.T) {
+	ctx := context.Background()
+	for name, store := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			g := New(testConfig(), store)
+
+			var (
+				wg       sync.WaitGroup
+				mu       sync.Mutex
+				admitted []*Attempt
+			)
+			for i := 0; i < 10; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					attempt, wait, err := g.Begin(ctx, "0900 000 001", "10.0.0.1")
+					if err != nil {
+						t.Error(err)
+						return
+					}
+					if wait == 0 {
+						mu.Lock()
+						admitted = append(admitted, attempt)
+						mu.Unlock()
+					}
+				}()
+			}
+			wg.Wait()
+
+			if len(admitted) != 3 {
+				t.Fatalf("%d concurrent attempts admitted, want 3", len(admitted))
+			}
+
+			// Failing all of them locks the phone number out
+			locked := false
+			for _, attempt := range admitted {
+				l, err := attempt.Failed(ctx)
+				if err != nil {
+					t.Fatal(err)
+				}
+				locked = locked || l
+			}
+			if !locked {
+				t.Error("phone number not locked out after reaching max failures")
+			}
+			if _, wait, _ := g.Begin(ctx, "0900000001", "10.0.0.2"); wait <= time.Minute {
+				t.Errorf("Begin() after lockout wait = %v, want the lockout", wait)
+			}
+		})
+	}
+}
+
+func TestGuardDelaysAfterFailures(t *testing.T) {
+	ctx := context.Background()
+	for name, store := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			cfg := testConfig()
+			cfg.MaxFailures, cfg.DelayAfter = 5, 2
+			g := New(cfg, store)
+
+			for i := 1; i <= 3; i++ {
+				attempt, wait, err := g.Begin(ctx, "0900000001", "10.0.0.1")
+				if err != nil || wait != 0 {
+					t.Fatalf("attempt %d: wait = %v, err = %v", i, wait, err)
+				}
+				if _, err := attempt.Failed(ctx); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			_, wait, err := g.Begin(ctx, "0900000001", "10.0.0.1")
+			if err != nil || wait <= 0 || wait > cfg.BaseDelay {
+				t.Errorf("Begin() after 3 failures wait = %v, err = %v, want up to %v", wait, err, cfg.BaseDelay)
+			}
+		})
+	}
+}
+
+func TestGuardSuccessKeepsIPFailuresOnly(t *testing.T) {
+	ctx := context.Background()
+	for name, store := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			cfg := testConfig()
+			cfg.MaxFailuresPerIP = 3
+			g := New(cfg, store)
+
+			// A failure on someone else's number counts against the IP
+			attempt, _, _ := g.Begin(ctx, "0900000002", "10.0.0.1")
+			if _, err := attempt.Failed(ctx); err != nil {
+				t.Fatal(err)
+			}
+
+			// Logging into an account of one's own, or the user service failing, doesn't use up the IP's attempts
+			for i := 0; i < 5; i++ {
+				attempt, wait, err := g.Begin(ctx, "0900000001", "10.0.0.1")
+				if err != nil || wait != 0 {
+					t.Fatalf("attempt %d: wait = %v, err = %v", i, wait, err)
+				}
+				if i%2 == 0 {
+					err = attempt.Succeeded(ctx)
+				} else {
+					err = attempt.Release(ctx)
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			for i := 0; i < 2; i++ {
+				attempt, wait, _ := g.Begin(ctx, "0900000003", "10.0.0.1")
+				if wait != 0 {
+					t.Fatalf("failure %d refused early, wait = %v", i+2, wait)
+				}
+				attempt.Failed(ctx)
+			}
+			if _, wait, _ := g.Begin(ctx, "0900000004", "10.0.0.1"); wait <= time.Minute {
+				t.Errorf("Begin() after the IP's max failures wait = %v, want the lockout", wait)
+			}
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package loginguard
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// reserveScript increments a count, starting its expiry with the first attempt, in one round trip
+var reserveScript = redis.NewScript(`
+local count = redis.call("INCR", KEYS[1])
+if count == 1 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[1])
+end
+return count
+`)
+
+// releaseScript decrements a count that is still there and above zero, leaving its expiry alone
+var releaseScript = redis.NewScript(`
+if tonumber(redis.call("GET", KEYS[1]) or "0") > 0 then
+	return redis.call("DECR", KEYS[1])
+end
+return 0
+`)
+
+// RedisStore keeps the counts in Redis, or any server speaking its protocol, so every gateway instance
+// of a cluster shares them. Keys expire on their own.
+type RedisStore struct {
+	client redis.UniversalClient
+	prefix string
+}
+
+// NewRedisStore returns a store keeping its keys under prefix, e.g. "eco-taxi:login:".
+func NewRedisStore(client redis.UniversalClient, prefix string) *RedisStore {
+	return &RedisStore{client: client, prefix: prefix}
+}
+
+func (s *RedisStore) Reserve(ctx context.Context, key string, window time.Duration) (int, error) {
+	count, err := reserveScript.Run(ctx, s.client, []string{s.prefix + "failures:" + key}, window.Milliseconds()).Int()
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+func (s *RedisStore) Release(ctx context.Context, key string) error {
+	return releaseScript.Run(ctx, s.client, []string{s.prefix + "failures:" + key}).Err()
+}
+
+func (s *RedisStore) Lock(ctx context.Context, key string, d time.Duration) error {
+	return s.client.Set(ctx, s.prefix+"lock:"+key, 1, d).Err()
+}
+
+func (s *RedisStore) LockedFor(ctx context.Context, key string) (time.Duration, error) {
+	ttl, err := s.client.PTTL(ctx, s.prefix+"lock:"+key).Result()
+	if err != nil {
+		return 0, err
+	}
+	// PTTL reports a missing key or one without expiry as a negative duration
+	return max(ttl, 0), nil
+}
+
+func (s *RedisStore) Reset(ctx context.Context, key string) error {
+	return s.client.Del(ctx, s.prefix+"failures:"+key).Err()
+}
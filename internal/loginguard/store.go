@@ -0,0 +1,118 @@
+package loginguard
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Store keeps failure counts and lockouts by key. A count includes the attempts still in progress, which
+// reserve their place before being made. Implementations must be safe for concurrent use, and shared
+// between gateway instances for the limits to hold across a cluster.
+type Store interface {
+	// Reserve atomically increments the key's count and returns it, so concurrent attempts each get their
+	// own. The count expires window after its first attempt.
+	Reserve(ctx context.Context, key string, window time.Duration) (int, error)
+	// Release takes back a reservation of an attempt that didn't fail, never going below zero.
+	Release(ctx context.Context, key string) error
+	// Lock refuses the key for d.
+	Lock(ctx context.Context, key string, d time.Duration) error
+	// LockedFor returns how long the key stays refused, 0 if it isn't.
+	LockedFor(ctx context.Context, key string) (time.Duration, error)
+	// Reset forgets the key's failures, but not a lockout in progress.
+	Reset(ctx context.Context, key string) error
+}
+
+// MemoryStore keeps the counts in memory, for a single gateway instance.
+type MemoryStore struct {
+	mu       sync.Mutex
+	failures map[string]counter
+	locks    map[string]time.Time
+	sweptAt  time.Time
+}
+
+type counter struct {
+	count   int
+	expires time.Time
+}
+
+// NewMemoryStore returns an empty in-memory store.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{failures: map[string]counter{}, locks: map[string]time.Time{}}
+}
+
+func (s *MemoryStore) Reserve(_ context.Context, key string, window time.Duration) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.sweep(now)
+
+	c, ok := s.failures[key]
+	if !ok || now.After(c.expires) {
+		c = counter{expires: now.Add(window)}
+	}
+	c.count++
+	s.failures[key] = c
+	return c.count, nil
+}
+
+func (s *MemoryStore) Release(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.failures[key]
+	if !ok || time.Now().After(c.expires) || c.count == 0 {
+		return nil
+	}
+	c.count--
+	s.failures[key] = c
+	return nil
+}
+
+func (s *MemoryStore) Lock(_ context.Context, key string, d time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.locks[key] = time.Now().Add(d)
+	return nil
+}
+
+func (s *MemoryStore) LockedFor(_ context.Context, key string) (time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	until, ok := s.locks[key]
+	if !ok {
+		return 0, nil
+	}
+	return max(time.Until(until), 0), nil
+}
+
+func (s *MemoryStore) Reset(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.failures, key)
+	return nil
+}
+
+// sweep forgets the failure counts whose window has passed and the lockouts that have ended, which would
+// otherwise stay for every phone number and IP ever tried. Reserve runs it under s.mu, once a minute at most.
+func (s *MemoryStore) sweep(now time.Time) {
+	if now.Sub(s.sweptAt) < time.Minute {
+		return
+	}
+	s.sweptAt = now
+
+	for key, c := range s.failures {
+		if now.After(c.expires) {
+			delete(s.failures, key)
+		}
+	}
+	for key, until := range s.locks {
+		if now.After(until) {
+			delete(s.locks, key)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/haiyen11231/eco-taxi-api-gateway/internal/logger"
+	"github.com/haiyen11231/eco-taxi-api-gateway/internal/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AuthenticateAdmin rejects requests to the admin server without the Bearer admin token. Without a token
+// every request is let through, which the config only allows on a loopback address.
+func AuthenticateAdmin(token string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if token == "" {
+			ctx.Next()
+			return
+		}
+
+		// Comparing in constant time, so the token can't be guessed from how long a rejection takes
+		presented, ok := strings.CutPrefix(ctx.GetHeader("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(presented), []byte(token)) != 1 {
+			logger.FromContext(ctx).Warn("Invalid admin token")
+			utils.ResponseError(ctx, http.StatusUnauthorized, "Unauthorized!")
+			return
+		}
+
+		ctx.Next()
+	}
+}
@@ -0,0 +1,84 @@
+package middleware
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/haiyen11231/eco-taxi-api-gateway/internal/apikey"
+	"github.com/haiyen11231/eco-taxi-api-gateway/internal/backend"
+	"github.com/haiyen11231/eco-taxi-api-gateway/internal/logger"
+	"github.com/haiyen11231/eco-taxi-api-gateway/internal/telemetry"
+	"github.com/haiyen11231/eco-taxi-api-gateway/internal/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// APIKeyHeader carries the API keys of partners calling the gateway server-to-server.
+const APIKeyHeader = "X-API-Key"
+
+// AuthenticateAPIKey authenticates requests carrying an X-API-Key header with that key, and leaves the
+// others to authenticateUser. Key callers get the apikey.Role role and the key's scopes, stored in the gin
+// context like a user's, together with "api_key_id" and "api_key_owner". Their "user_id" is the key's
+// account, the rider they act for; keys without one have none.
+func AuthenticateAPIKey(keys *apikey.Keys, authenticateUser gin.HandlerFunc) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		presented := ctx.GetHeader(APIKeyHeader)
+		if presented == "" {
+			authenticateUser(ctx)
+			return
+		}
+
+		key, err := keys.Authenticate(ctx.Request.Context(), presented)
+
+		// If the key is unknown, expired or revoked, returns a 401 Unauthorized error
+		if errors.Is(err, apikey.ErrInvalidKey) {
+			logger.FromContext(ctx).Warn("Invalid API key")
+			telemetry.AuthAttempts.WithLabelValues(telemetry.AuthFailure).Inc()
+			utils.ResponseError(ctx, http.StatusUnauthorized, "Unauthorized!")
+			return
+		}
+
+		if err != nil {
+			logger.FromContext(ctx).Error("Failed to check API key", "error", err)
+			telemetry.AuthAttempts.WithLabelValues(telemetry.AuthError).Inc()
+			utils.ResponseError(ctx, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		telemetry.AuthAttempts.WithLabelValues(telemetry.AuthSuccess).Inc()
+		addLogAttrs(ctx, "api_key_id", key.ID, "api_key_owner", key.Owner)
+
+		// Enforcing the key's own rate limit, so one partner can't crowd out the others
+		if ok, retryAfter := keys.Allow(key); !ok {
+			logger.FromContext(ctx).Warn("API key rate limit exceeded", "rate_limit", key.RateLimit)
+			ctx.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			utils.ResponseError(ctx, http.StatusTooManyRequests, "API key rate limit exceeded")
+			return
+		}
+
+		ctx.Set("api_key_id", key.ID)
+		ctx.Set("api_key_owner", key.Owner)
+		ctx.Set("roles", []string{apikey.Role})
+		ctx.Set("scopes", key.Scopes)
+
+		// Forwarding the key and its grants to the backends
+		appendOutgoingMetadata(ctx,
+			backend.APIKeyIDHeader, key.ID,
+			backend.APIKeyOwnerHeader, key.Owner,
+			backend.UserRolesHeader, apikey.Role,
+		)
+		if len(key.Scopes) > 0 {
+			appendOutgoingMetadata(ctx, backend.UserScopesHeader, strings.Join(key.Scopes, ","))
+		}
+		if key.AccountID != 0 {
+			ctx.Set("user_id", key.AccountID)
+			appendOutgoingMetadata(ctx, backend.UserIDHeader, strconv.FormatUint(key.AccountID, 10))
+			addLogAttrs(ctx, "user_id", key.AccountID)
+		}
+
+		ctx.Next()
+	}
+}
@@ -1,60 +1,67 @@
 package middleware
 
 import (
-	"context"
-	"log"
+	"errors"
 	"net/http"
-	"os"
+	"strconv"
 	"strings"
-	"time"
 
-	"github.com/haiyen11231/eco-taxi-api-gateway/internal/grpc/pb"
+	"github.com/haiyen11231/eco-taxi-api-gateway/internal/auth"
+	"github.com/haiyen11231/eco-taxi-api-gateway/internal/backend"
+	"github.com/haiyen11231/eco-taxi-api-gateway/internal/logger"
+	"github.com/haiyen11231/eco-taxi-api-gateway/internal/telemetry"
 	"github.com/haiyen11231/eco-taxi-api-gateway/internal/utils"
 
 	"github.com/gin-gonic/gin"
 )
 
-func AuthenticateUser (ctx *gin.Context) {
-	// Extracting and validating the Bearer token from incoming requests
-	token := strings.TrimPrefix(ctx.GetHeader("Authorization"), "Bearer ")
+// AuthenticateUser rejects requests without a valid Bearer access token, and stores the caller's user ID,
+// roles and scopes in the gin context as "user_id", "roles" and "scopes".
+func AuthenticateUser(authenticator auth.Authenticator) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		// Extracting and validating the Bearer token from incoming requests
+		token := strings.TrimPrefix(ctx.GetHeader("Authorization"), "Bearer ")
 
-	if token == "" {
-		log.Println("Token required")
-		utils.ResponseError(ctx, http.StatusUnauthorized, "Unauthorized!")
-		return
-	}
+		if token == "" {
+			logger.FromContext(ctx).Warn("Token required")
+			telemetry.AuthAttempts.WithLabelValues(telemetry.AuthFailure).Inc()
+			utils.ResponseError(ctx, http.StatusUnauthorized, "Unauthorized!")
+			return
+		}
 
-	// Establishing a gRPC connection
-	conn, err := utils.GRPCClient(os.Getenv("GRPC_USER_HOST"))
-	if err != nil {
-		log.Println("Error Connection to GRPC", err)
-		utils.ResponseError(ctx, http.StatusUnauthorized, "Unauthorized!")
-		return
-	}
-	defer conn.Close()
-
-	client := pb.NewUserServiceClient(conn)
-	c, cancel := context.WithTimeout(context.Background(), time.Second)
-	defer cancel()
-
-	// Sending an AuthenticateUserRequest with user token to the gRPC service for authentication
-	response, err := client.AuthenticateUser(c, &pb.AuthenticateUserRequest{
-		Token: token,
-	})
-	
-	// If authentication fails, logs the error and returns a 401 Unauthorized error. On success, it sends a success response.
-	if err != nil {
-		log.Println("Failed to authenticate", err)
-		utils.ResponseError(ctx, http.StatusUnauthorized, "Unauthorized!")
-		return
-	}
-	
-	if !response.IsValid {
-		log.Println("Error ", err)
-		utils.ResponseError(ctx, http.StatusUnauthorized, "Unauthorized!")
-		return
-	}
+		// Checking the token, either locally or with the user service depending on the authenticator
+		identity, err := authenticator.Authenticate(ctx.Request.Context(), token)
+
+		// If the token is invalid, logs the reason and returns a 401 Unauthorized error. If it couldn't be checked, responds with the cause.
+		if errors.Is(err, auth.ErrInvalidToken) {
+			logger.FromContext(ctx).Warn("Invalid token", "error", err)
+			telemetry.AuthAttempts.WithLabelValues(telemetry.AuthFailure).Inc()
+			utils.ResponseError(ctx, http.StatusUnauthorized, "Unauthorized!")
+			return
+		}
 
-	ctx.Set("user_id", response.UserId)
-	ctx.Next()
-}
\ No newline at end of file
+		if err != nil {
+			logger.FromContext(ctx).Error("Failed to authenticate", "error", err)
+			telemetry.AuthAttempts.WithLabelValues(telemetry.AuthError).Inc()
+			utils.ResponseBackendError(ctx, err, http.StatusUnauthorized, utils.CodeUnauthorized, "Unauthorized!")
+			return
+		}
+
+		telemetry.AuthAttempts.WithLabelValues(telemetry.AuthSuccess).Inc()
+		ctx.Set("user_id", identity.UserID)
+		ctx.Set("roles", identity.Roles)
+		ctx.Set("scopes", identity.Scopes)
+
+		// Forwarding the authenticated user to the backends and adding it to the request's log lines
+		appendOutgoingMetadata(ctx, backend.UserIDHeader, strconv.FormatUint(identity.UserID, 10))
+		if len(identity.Roles) > 0 {
+			appendOutgoingMetadata(ctx, backend.UserRolesHeader, strings.Join(identity.Roles, ","))
+		}
+		if len(identity.Scopes) > 0 {
+			appendOutgoingMetadata(ctx, backend.UserScopesHeader, strings.Join(identity.Scopes, ","))
+		}
+		addLogAttrs(ctx, "user_id", identity.UserID)
+
+		ctx.Next()
+	}
+}
@@ -0,0 +1,76 @@
+package middleware
+
+import (
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-contrib/cors"
+	"github.com/gin-gonic/gin"
+)
+
+// gatewayHeaders are always allowed in cross-origin requests, since the gateway itself reads them
+var gatewayHeaders = []string{"traceparent", "tracestate", "Idempotency-Key", RequestTimeoutHeader, RequestIDHeader}
+
+// CORSPolicy is the set of cross-origin requests allowed on a group of routes.
+type CORSPolicy struct {
+	// AllowOrigins lists the allowed origins. "*" allows any origin, and "https://*.example.com"
+	// allows every subdomain of example.com.
+	AllowOrigins     []string
+	AllowMethods     []string
+	AllowHeaders     []string
+	ExposeHeaders    []string
+	AllowCredentials bool
+	// MaxAge is how long browsers may cache a preflight response.
+	MaxAge time.Duration
+}
+
+// corsConfig converts the policy to the configuration of the gin-contrib/cors middleware
+func (p CORSPolicy) corsConfig() cors.Config {
+	config := cors.Config{
+		AllowMethods:     p.AllowMethods,
+		AllowHeaders:     append(slices.Clone(p.AllowHeaders), gatewayHeaders...),
+		ExposeHeaders:    append(slices.Clone(p.ExposeHeaders), RequestIDHeader),
+		AllowCredentials: p.AllowCredentials,
+		MaxAge:           p.MaxAge,
+		AllowWildcard:    true,
+	}
+
+	if slices.Contains(p.AllowOrigins, "*") {
+		config.AllowAllOrigins = true
+	} else {
+		config.AllowOrigins = p.AllowOrigins
+	}
+	return config
+}
+
+// CORS answers preflight requests and sets the CORS headers using the policy of the longest route prefix
+// matching the request path, or defaultPolicy. It must be installed on the router rather than on a group,
+// as preflight requests don't match the group's routes.
+func CORS(defaultPolicy CORSPolicy, routes map[string]CORSPolicy) gin.HandlerFunc {
+	type route struct {
+		prefix  string
+		handler gin.HandlerFunc
+	}
+
+	var handlers []route
+	for prefix, policy := range routes {
+		handlers = append(handlers, route{strings.TrimSuffix(prefix, "/"), cors.New(policy.corsConfig())})
+	}
+	// Checking longer prefixes first, so /v1/trip/confirm can override /v1/trip
+	slices.SortFunc(handlers, func(a, b route) int {
+		return len(b.prefix) - len(a.prefix)
+	})
+	defaultHandler := cors.New(defaultPolicy.corsConfig())
+
+	return func(ctx *gin.Context) {
+		path := ctx.Request.URL.Path
+		for _, r := range handlers {
+			if path == r.prefix || strings.HasPrefix(path, r.prefix+"/") {
+				r.handler(ctx)
+				return
+			}
+		}
+		defaultHandler(ctx)
+	}
+}
@@ -0,0 +1,63 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/haiyen11231/eco-taxi-api-gateway/internal/logger"
+	"github.com/haiyen11231/eco-taxi-api-gateway/internal/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequestTimeoutHeader lets a client ask for a shorter deadline, e.g. "X-Request-Timeout: 1500ms".
+const RequestTimeoutHeader = "X-Request-Timeout"
+
+// MinRequestTimeout is the shortest deadline a client can ask for, below which no backend call could succeed.
+const MinRequestTimeout = 100 * time.Millisecond
+
+// DeadlineConfig holds the server-side request timeouts.
+type DeadlineConfig struct {
+	// Default is the timeout of routes without an entry in Routes.
+	Default time.Duration
+	// Routes maps "METHOD /route/template" (e.g. "POST /v1/trip/confirm") to its timeout.
+	Routes map[string]time.Duration
+}
+
+// Deadline attaches a deadline to the request context, which every backend call derives its context from.
+// The deadline is the route's configured timeout, or the client's X-Request-Timeout when that is shorter.
+// Because the context comes from the HTTP request, a client that disconnects also cancels the backend work.
+func Deadline(cfg DeadlineConfig) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		timeout, ok := cfg.Routes[ctx.Request.Method+" "+ctx.FullPath()]
+		if !ok {
+			timeout = cfg.Default
+		}
+
+		if header := ctx.GetHeader(RequestTimeoutHeader); header != "" {
+			requested, err := time.ParseDuration(header)
+			if err != nil || requested < MinRequestTimeout {
+				logger.FromContext(ctx).Warn("Invalid request timeout", "header", header)
+				utils.ResponseError(ctx, http.StatusBadRequest, "Invalid "+RequestTimeoutHeader+" header, it must be a duration of at least "+MinRequestTimeout.String())
+				return
+			}
+
+			// The server maximum caps whatever the client asks for
+			if timeout <= 0 || requested < timeout {
+				timeout = requested
+			}
+		}
+
+		if timeout <= 0 {
+			ctx.Next()
+			return
+		}
+
+		c, cancel := context.WithTimeout(ctx.Request.Context(), timeout)
+		defer cancel()
+
+		ctx.Request = ctx.Request.WithContext(c)
+		ctx.Next()
+	}
+}
@@ -0,0 +1,51 @@
+package middleware
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/haiyen11231/eco-taxi-api-gateway/internal/logger"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Logger attaches a request-scoped logger carrying the request ID, method and route to the request context,
+// and writes one access log line per request with its status and latency.
+func Logger() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		start := time.Now()
+
+		l := slog.Default().With(
+			"request_id", ctx.GetString("request_id"),
+			"method", ctx.Request.Method,
+			"route", ctx.FullPath(),
+		)
+		ctx.Request = ctx.Request.WithContext(logger.WithContext(ctx.Request.Context(), l))
+
+		ctx.Next()
+
+		status := ctx.Writer.Status()
+		level := slog.LevelInfo
+		switch {
+		case status >= http.StatusInternalServerError:
+			level = slog.LevelError
+		case status >= http.StatusBadRequest:
+			level = slog.LevelWarn
+		}
+
+		// The logger is read again since the auth middleware adds the user ID to it
+		logger.FromContext(ctx).Log(ctx, level, "Request completed",
+			"path", ctx.Request.URL.Path,
+			"status", status,
+			"latency_ms", float64(time.Since(start).Microseconds())/1000,
+			"client_ip", ctx.ClientIP(),
+		)
+	}
+}
+
+// addLogAttrs adds attributes to the request-scoped logger for the rest of the request.
+func addLogAttrs(ctx *gin.Context, args ...any) {
+	l := logger.FromContext(ctx).With(args...)
+	ctx.Request = ctx.Request.WithContext(logger.WithContext(ctx.Request.Context(), l))
+}
@@ -0,0 +1,30 @@
+package middleware
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/haiyen11231/eco-taxi-api-gateway/internal/telemetry"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Metrics records the count, latency and concurrency of HTTP requests, labelled by route template.
+func Metrics() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		telemetry.HTTPRequestsInFlight.Inc()
+		defer telemetry.HTTPRequestsInFlight.Dec()
+
+		start := time.Now()
+		ctx.Next()
+
+		route := ctx.FullPath()
+		if route == "" {
+			route = telemetry.UnmatchedRoute
+		}
+		status := strconv.Itoa(ctx.Writer.Status())
+
+		telemetry.HTTPRequests.WithLabelValues(ctx.Request.Method, route, status).Inc()
+		telemetry.HTTPRequestDuration.WithLabelValues(ctx.Request.Method, route, status).Observe(time.Since(start).Seconds())
+	}
+}
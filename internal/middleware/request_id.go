@@ -0,0 +1,53 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"regexp"
+
+	"github.com/haiyen11231/eco-taxi-api-gateway/internal/backend"
+
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc/metadata"
+)
+
+// RequestIDHeader is the HTTP header carrying the request ID in both directions.
+const RequestIDHeader = "X-Request-ID"
+
+// validRequestID limits client-supplied IDs to something safe to log and forward.
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// RequestID accepts the client's X-Request-ID or generates one, echoes it in the response and stores it
+// in the gin context as "request_id". The ID, client IP and user agent are attached to the request context
+// as outgoing gRPC metadata, so every backend call made for this request carries them.
+func RequestID() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		requestId := ctx.GetHeader(RequestIDHeader)
+		if !validRequestID.MatchString(requestId) {
+			requestId = newRequestID()
+		}
+
+		ctx.Set("request_id", requestId)
+		ctx.Header(RequestIDHeader, requestId)
+
+		appendOutgoingMetadata(ctx,
+			backend.RequestIDHeader, requestId,
+			backend.ClientIPHeader, ctx.ClientIP(),
+			backend.UserAgentHeader, ctx.Request.UserAgent(),
+		)
+
+		ctx.Next()
+	}
+}
+
+// appendOutgoingMetadata adds key/value pairs to the metadata sent with every backend call of the request.
+func appendOutgoingMetadata(ctx *gin.Context, kv ...string) {
+	c := metadata.AppendToOutgoingContext(ctx.Request.Context(), kv...)
+	ctx.Request = ctx.Request.WithContext(c)
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
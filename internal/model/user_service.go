@@ -7,13 +7,27 @@ type SignUpUserData struct {
 	Password    string `json:"password" binding:"required"`
 }
 
+type VerifyPhoneData struct {
+	PhoneNumber string `json:"phone_number" binding:"required"`
+	Code        string `json:"code" binding:"required"`
+}
+
+type ResendPhoneCodeData struct {
+	PhoneNumber string `json:"phone_number" binding:"required"`
+}
+
 type LogInUserData struct {
 	PhoneNumber string `json:"phone_number" binding:"required"`
 	Password    string `json:"password" binding:"required"`
 }
 
-type ForgotPasswordUserData struct {
-	Email       string `json:"email" binding:"required"`
+type RequestPasswordResetData struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+type ConfirmPasswordResetData struct {
+	Email       string `json:"email" binding:"required,email"`
+	Code        string `json:"code" binding:"required"`
 	NewPassword string `json:"new_password" binding:"required"`
 }
 
@@ -35,3 +49,13 @@ type UpdateDistanceUserData struct {
 type AuthenticateUserData struct {
 	Token string `json:"token" binding:"required"`
 }
+
+type RefreshTokenData struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+// RefreshTokenResult carries the rotated refresh token only when refresh tokens aren't kept in a cookie
+type RefreshTokenResult struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+}
@@ -0,0 +1,79 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// Sinks accepted by the gateway configuration.
+const (
+	SinkLog  = "log"
+	SinkFile = "file"
+)
+
+// Message is a notification to a user, such as a verification code.
+type Message struct {
+	// To is the recipient's email address or phone number.
+	To      string `json:"to"`
+	Subject string `json:"subject,omitempty"`
+	Text    string `json:"text"`
+}
+
+// Notifier delivers messages to users. Production deployments plug in an email or SMS provider; the log
+// and file sinks are meant for local development.
+type Notifier interface {
+	Send(ctx context.Context, message Message) error
+}
+
+// Log writes the recipient and subject of messages to the gateway's log. The text, which holds codes and
+// reset links, is only written when Bodies is set, for local development.
+type Log struct {
+	Bodies bool
+}
+
+func (l Log) Send(ctx context.Context, message Message) error {
+	if l.Bodies {
+		slog.InfoContext(ctx, "Notification", "to", message.To, "subject", message.Subject, "text", message.Text)
+		return nil
+	}
+	slog.InfoContext(ctx, "Notification", "to", message.To, "subject", message.Subject)
+	return nil
+}
+
+// File appends messages to a file, one JSON object per line.
+type File struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewFile returns a sink appending to the file at path, which is created if needed.
+func NewFile(path string) *File {
+	return &File{path: path}
+}
+
+func (f *File) Send(_ context.Context, message Message) error {
+	line, err := json.Marshal(struct {
+		Time time.Time `json:"time"`
+		Message
+	}{time.Now().UTC(), message})
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	file, err := os.OpenFile(f.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,20 @@
+// Package redistest provides the Redis server the tests of the Redis stores run against.
+package redistest
+
+import (
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// NewClient starts an in-memory Redis server for the test and returns a client connected to it. Both are
+// closed when the test ends.
+func NewClient(t testing.TB) *redis.Client {
+	t.Helper()
+
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return client
+}
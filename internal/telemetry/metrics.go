@@ -0,0 +1,137 @@
+package telemetry
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/haiyen11231/eco-taxi-api-gateway/internal/backend"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/status"
+)
+
+// Route labels always come from the route template (gin's FullPath), never the raw URL,
+// so IDs such as /v1/trip/:id do not create a series per value.
+var (
+	HTTPRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gateway_http_requests_total",
+		Help: "HTTP requests handled, by method, route template and status code.",
+	}, []string{"method", "route", "status"})
+
+	HTTPRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "gateway_http_request_duration_seconds",
+		Help:    "HTTP request latency, by method, route template and status code.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	HTTPRequestsInFlight = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "gateway_http_requests_in_flight",
+		Help: "HTTP requests currently being served.",
+	})
+
+	GRPCClientCalls = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gateway_grpc_client_calls_total",
+		Help: "gRPC calls made to the backends, by service, method and status code. Every retry attempt is counted.",
+	}, []string{"service", "method", "code"})
+
+	GRPCClientCallDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "gateway_grpc_client_call_duration_seconds",
+		Help:    "gRPC call latency, by service, method and status code.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"service", "method", "code"})
+
+	AuthAttempts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gateway_auth_attempts_total",
+		Help: "Token checks in the auth middleware, by result (success, failure or error).",
+	}, []string{"result"})
+
+	LoginAttempts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gateway_login_attempts_total",
+		Help: "Login attempts, by result (success, failure, locked or error). Locked attempts were refused without calling the user service.",
+	}, []string{"result"})
+)
+
+// Auth attempt results.
+const (
+	AuthSuccess = "success"
+	AuthFailure = "failure"
+	AuthError   = "error"
+	// LoginLocked counts login attempts refused while the phone number or client IP is locked out
+	LoginLocked = "locked"
+)
+
+// UnmatchedRoute labels requests that did not match any route.
+const UnmatchedRoute = "unmatched"
+
+// GRPCClientInterceptor records the count and latency of every backend call attempt.
+func GRPCClientInterceptor(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	start := time.Now()
+	err := invoker(ctx, method, req, reply, cc, opts...)
+
+	service, name := splitMethod(method)
+	code := status.Code(err).String()
+	GRPCClientCalls.WithLabelValues(service, name, code).Inc()
+	GRPCClientCallDuration.WithLabelValues(service, name, code).Observe(time.Since(start).Seconds())
+
+	return err
+}
+
+// splitMethod splits "/package.Service/Method" into its service and method names.
+func splitMethod(fullMethod string) (string, string) {
+	service, method, ok := strings.Cut(strings.TrimPrefix(fullMethod, "/"), "/")
+	if !ok {
+		return "unknown", fullMethod
+	}
+	return service, method
+}
+
+// connectionStates and circuitStates list every label value so that inactive states report 0.
+var (
+	connectionStates = []connectivity.State{connectivity.Idle, connectivity.Connecting, connectivity.Ready, connectivity.TransientFailure, connectivity.Shutdown}
+	circuitStates    = []string{backend.CircuitClosed, backend.CircuitOpen, backend.CircuitHalfOpen}
+)
+
+type poolCollector struct {
+	source     func() []backend.ConnectionState
+	connection *prometheus.Desc
+	circuit    *prometheus.Desc
+}
+
+// RegisterConnectionPool exports the state of the backend connections and their circuit breakers,
+// read from source at scrape time.
+func RegisterConnectionPool(source func() []backend.ConnectionState) {
+	prometheus.MustRegister(&poolCollector{
+		source: source,
+		connection: prometheus.NewDesc("gateway_backend_connection_state",
+			"Connectivity state of each backend connection; 1 for the current state.", []string{"service", "state"}, nil),
+		circuit: prometheus.NewDesc("gateway_backend_circuit_state",
+			"Circuit breaker state of each backend; 1 for the current state.", []string{"service", "state"}, nil),
+	})
+}
+
+func (c *poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.connection
+	ch <- c.circuit
+}
+
+func (c *poolCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, s := range c.source() {
+		for _, state := range connectionStates {
+			ch <- prometheus.MustNewConstMetric(c.connection, prometheus.GaugeValue, boolValue(s.State == state.String()), s.Service, state.String())
+		}
+		for _, state := range circuitStates {
+			ch <- prometheus.MustNewConstMetric(c.circuit, prometheus.GaugeValue, boolValue(s.Circuit == state), s.Service, state)
+		}
+	}
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,115 @@
+package utils
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ProblemContentType is the media type of error responses, defined by RFC 7807.
+const ProblemContentType = "application/problem+json"
+
+// ProblemTypeBase is prefixed to a problem's stable code to form its type URI.
+const ProblemTypeBase = "urn:eco-taxi:problem:"
+
+// Stable codes of the errors raised by the gateway itself. Backends choose their own codes, such as
+// "trip.booking_already_active", by attaching a google.rpc.ErrorInfo whose reason is the code.
+const (
+	CodeInvalidRequest     = "request.invalid"
+	CodeUnauthorized       = "auth.unauthorized"
+	CodeForbidden          = "auth.forbidden"
+	CodeNotFound           = "resource.not_found"
+	CodeConflict           = "resource.conflict"
+	CodeRateLimited        = "request.rate_limited"
+	CodeInternal           = "gateway.internal"
+	CodeBackendUnavailable = "backend.unavailable"
+	CodeBackendTimeout     = "backend.timeout"
+	CodeCircuitOpen        = "backend.circuit_open"
+)
+
+// LegacyErrors switches error responses back to the {code, error, message} JSON shape used before problem+json.
+var LegacyErrors bool
+
+// Problem is an RFC 7807 error response. Code is the stable, machine-readable error code clients branch on,
+// and Type is the same code as a URI. Instance is the request ID.
+type Problem struct {
+	Type          string         `json:"type"`
+	Title         string         `json:"title"`
+	Status        int            `json:"status"`
+	Detail        string         `json:"detail,omitempty"`
+	Instance      string         `json:"instance,omitempty"`
+	Code          string         `json:"code"`
+	InvalidParams []InvalidParam `json:"invalid_params,omitempty"`
+}
+
+// InvalidParam is a request parameter that failed validation, in the gateway or in a backend.
+type InvalidParam struct {
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+}
+
+// defaultCodes are used for problems that don't carry a more specific code
+var defaultCodes = map[int]string{
+	http.StatusBadRequest:         CodeInvalidRequest,
+	http.StatusUnauthorized:       CodeUnauthorized,
+	http.StatusForbidden:          CodeForbidden,
+	http.StatusNotFound:           CodeNotFound,
+	http.StatusConflict:           CodeConflict,
+	http.StatusTooManyRequests:    CodeRateLimited,
+	http.StatusServiceUnavailable: CodeBackendUnavailable,
+	http.StatusGatewayTimeout:     CodeBackendTimeout,
+}
+
+func defaultCode(status int) string {
+	if code, ok := defaultCodes[status]; ok {
+		return code
+	}
+	if status >= http.StatusInternalServerError {
+		return CodeInternal
+	}
+	return CodeInvalidRequest
+}
+
+// ResponseProblem aborts the request with p, filling in the fields left empty from its status and the request ID.
+func ResponseProblem(context *gin.Context, p Problem) {
+	if p.Code == "" {
+		p.Code = defaultCode(p.Status)
+	}
+	if p.Type == "" {
+		p.Type = ProblemTypeBase + p.Code
+	}
+	if p.Title == "" {
+		p.Title = http.StatusText(p.Status)
+	}
+	if p.Instance == "" {
+		p.Instance = context.GetString("request_id")
+	}
+
+	if LegacyErrors {
+		responseLegacyError(context, p)
+		return
+	}
+
+	// Setting the content type first, as the JSON renderer only sets one when it is missing
+	context.Header("Content-Type", ProblemContentType)
+	context.AbortWithStatusJSON(p.Status, p)
+}
+
+// responseLegacyError writes p in the {code, error, message} shape, with backend field violations as "fields"
+func responseLegacyError(context *gin.Context, p Problem) {
+	body := gin.H{
+		"code":    p.Status,
+		"error":   http.StatusText(p.Status),
+		"message": p.Detail,
+	}
+
+	if len(p.InvalidParams) > 0 {
+		fields := make([]gin.H, 0, len(p.InvalidParams))
+		for _, param := range p.InvalidParams {
+			fields = append(fields, gin.H{"field": param.Name, "description": param.Reason})
+		}
+		body["fields"] = fields
+	}
+
+	context.AbortWithStatusJSON(p.Status, body)
+}
@@ -1,20 +1,134 @@
 package utils
 
 import (
+	"errors"
+	"math"
 	"net/http"
+	"reflect"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 func ResponseError(context *gin.Context, code int, message string) {
-	context.AbortWithStatusJSON(code, gin.H{
-		"code":    code,
-		"error":   http.StatusText(code),
-		"message": strings.Replace(message, "rpc error: code = Unknown desc = ", "", -1),
+	ResponseProblem(context, Problem{
+		Status: code,
+		Detail: trimRPCError(message),
 	})
 }
 
+// trimRPCError strips the prefix a gRPC error adds to the backend's message
+func trimRPCError(message string) string {
+	return strings.Replace(message, "rpc error: code = Unknown desc = ", "", -1)
+}
+
+// ResponseBindError responds to a request body that failed to bind. Fields that failed validation are
+// listed in the problem's invalid_params.
+func ResponseBindError(context *gin.Context, err error, message string) {
+	problem := Problem{Status: http.StatusBadRequest, Detail: message}
+
+	var validationErrors validator.ValidationErrors
+	if errors.As(err, &validationErrors) {
+		for _, fieldError := range validationErrors {
+			problem.InvalidParams = append(problem.InvalidParams, InvalidParam{
+				Name:   fieldError.Field(),
+				Reason: validationReason(fieldError),
+			})
+		}
+	}
+
+	ResponseProblem(context, problem)
+}
+
+func validationReason(fieldError validator.FieldError) string {
+	switch fieldError.Tag() {
+	case "required":
+		return "is required"
+	default:
+		return "must satisfy the " + fieldError.Tag() + " rule"
+	}
+}
+
+// UseJSONFieldNames makes validation errors name fields after their JSON keys rather than the Go struct fields.
+func UseJSONFieldNames() {
+	validate, ok := binding.Validator.Engine().(*validator.Validate)
+	if !ok {
+		return
+	}
+
+	validate.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			return ""
+		}
+		return name
+	})
+}
+
+// httpStatusFromCode translates the gRPC codes returned by the backends to the HTTP status sent to the client
+var httpStatusFromCode = map[codes.Code]int{
+	codes.InvalidArgument:  http.StatusBadRequest,
+	codes.Unauthenticated:  http.StatusUnauthorized,
+	codes.PermissionDenied: http.StatusForbidden,
+	codes.NotFound:         http.StatusNotFound,
+	codes.AlreadyExists:    http.StatusConflict,
+	codes.Unavailable:      http.StatusServiceUnavailable,
+	codes.DeadlineExceeded: http.StatusGatewayTimeout,
+}
+
+// ResponseBackendError responds to a failed backend call. Calls that were rejected because the backend's
+// circuit breaker is open fail fast with 503 and a Retry-After header. gRPC codes with an HTTP equivalent
+// are sent with that status, anything else with code. The detail is always message: the backend's own
+// text may be internal, so callers log it rather than send it. Client errors
+// carry the operation's errorCode, e.g. "trip.confirm_failed", unless the backend attached a stable code
+// of its own; its field violations are passed through too.
+func ResponseBackendError(context *gin.Context, err error, code int, errorCode, message string) {
+	var open interface{ RetryAfter() time.Duration }
+	if errors.As(err, &open) {
+		seconds := int(math.Ceil(open.RetryAfter().Seconds()))
+		context.Header("Retry-After", strconv.Itoa(max(seconds, 1)))
+		ResponseProblem(context, Problem{Status: http.StatusServiceUnavailable, Detail: err.Error(), Code: CodeCircuitOpen})
+		return
+	}
+
+	problem := Problem{Status: code, Detail: trimRPCError(message)}
+	st, ok := status.FromError(err)
+	if ok {
+		if httpCode, ok := httpStatusFromCode[st.Code()]; ok {
+			problem.Status = httpCode
+		}
+	}
+
+	// Unavailable and timed out backends keep the gateway's codes, which say more than the operation's
+	if problem.Status < http.StatusInternalServerError {
+		problem.Code = errorCode
+	}
+	if !ok {
+		ResponseProblem(context, problem)
+		return
+	}
+
+	for _, detail := range st.Details() {
+		switch detail := detail.(type) {
+		case *errdetails.ErrorInfo:
+			problem.Code = detail.GetReason()
+		case *errdetails.BadRequest:
+			for _, v := range detail.GetFieldViolations() {
+				problem.InvalidParams = append(problem.InvalidParams, InvalidParam{Name: v.GetField(), Reason: v.GetDescription()})
+			}
+		}
+	}
+
+	ResponseProblem(context, problem)
+}
+
 func ResponseSuccess(context *gin.Context, code int, data interface{}) {
 	context.JSON(code, data)
-}
\ No newline at end of file
+}
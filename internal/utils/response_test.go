@@ -0,0 +1,56 @@
+package utils
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestResponseBackendErrorCodes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	withErrorInfo, err := status.New(codes.FailedPrecondition, "a booking is already active").
+		WithDetails(&errdetails.ErrorInfo{Reason: "trip.booking_already_active"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus int
+		wantCode   string
+	}{
+		{"plain business error", status.Error(codes.FailedPrecondition, "no driver"), http.StatusBadRequest, "trip.confirm_failed"},
+		{"mapped business error", status.Error(codes.NotFound, "row 42 missing in bookings"), http.StatusNotFound, "trip.confirm_failed"},
+		{"backend's own code", withErrorInfo.Err(), http.StatusBadRequest, "trip.booking_already_active"},
+		{"unavailable backend", status.Error(codes.Unavailable, "down"), http.StatusServiceUnavailable, CodeBackendUnavailable},
+		{"timed out backend", status.Error(codes.DeadlineExceeded, "slow"), http.StatusGatewayTimeout, CodeBackendTimeout},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			ctx, _ := gin.CreateTestContext(w)
+
+			ResponseBackendError(ctx, tt.err, http.StatusBadRequest, "trip.confirm_failed", "Booking failed")
+
+			var problem Problem
+			if err := json.Unmarshal(w.Body.Bytes(), &problem); err != nil {
+				t.Fatal(err)
+			}
+			if w.Code != tt.wantStatus || problem.Code != tt.wantCode {
+				t.Errorf("got %d %s, want %d %s", w.Code, problem.Code, tt.wantStatus, tt.wantCode)
+			}
+			// The backend's message stays out of the response
+			if problem.Detail != "Booking failed" {
+				t.Errorf("detail = %q, want the fallback message", problem.Detail)
+			}
+		})
+	}
+}
@@ -0,0 +1,230 @@
+package verification
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+// ErrInvalidCode is returned for codes that are wrong, expired, used up or were never issued.
+var ErrInvalidCode = errors.New("invalid or expired verification code")
+
+// ErrPending is returned by IssueOnce when the subject already has a code for the purpose.
+var ErrPending = errors.New("a verification code is already pending")
+
+// Config sets how verification codes are issued and checked.
+type Config struct {
+	// TTL is how long a code can be used.
+	TTL time.Duration
+	// MaxAttempts is the number of guesses a subject gets within Window, whichever of its codes they are
+	// for, so asking for a new code doesn't bring more.
+	MaxAttempts int
+	// MaxCodes is the number of codes a subject can be issued within Window.
+	MaxCodes int
+	// Window is how long a subject's guesses and codes are counted, starting with the first of each.
+	Window time.Duration
+	// ResendInterval is the minimum time between two codes for the same subject.
+	ResendInterval time.Duration
+	// DataKey is the 32-byte AES key encrypting the data issued with the codes, so the store never holds it
+	// in the clear. Every instance sharing a store needs the same key. Without one, New generates a key,
+	// which only suits a store that doesn't outlive the gateway instance.
+	DataKey []byte
+}
+
+// Codes issues short-lived, one-time numeric codes, such as the ones confirming a password reset. Only the
+// hashes of the codes are stored, with optional data the code unlocks, encrypted.
+type Codes struct {
+	cfg   Config
+	store Store
+	aead  cipher.AEAD
+}
+
+// New returns codes kept in store.
+func New(cfg Config, store Store) (*Codes, error) {
+	key := cfg.DataKey
+	if len(key) == 0 {
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("verification data key: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Codes{cfg: cfg, store: store, aead: aead}, nil
+}
+
+// TTL returns how long codes can be used.
+func (c *Codes) TTL() time.Duration {
+	return c.cfg.TTL
+}
+
+// Issue creates a code for the subject, e.g. an email address, replacing any earlier one for the same
+// purpose. When the previous code is too recent, no code is issued and Issue returns how long to wait.
+func (c *Codes) Issue(ctx context.Context, purpose, subject string, data []byte) (string, time.Duration, error) {
+	key := purpose + ":" + subject
+
+	previous, ok, err := c.store.Get(ctx, key)
+	if err != nil {
+		return "", 0, err
+	}
+	if ok {
+		if wait := c.resendWait(previous); wait > 0 {
+			return "", wait, nil
+		}
+	}
+
+	sealed, err := c.seal(key, data)
+	if err != nil {
+		return "", 0, err
+	}
+	return c.issue(ctx, key, sealed, true)
+}
+
+// IssueOnce creates a code for the subject like Issue, but never replaces a pending one, whose data must
+// stay as it was issued, e.g. the registration a code sent to a phone number confirms. It returns
+// ErrPending instead; Resend gives the pending code's subject a new code for the same data.
+func (c *Codes) IssueOnce(ctx context.Context, purpose, subject string, data []byte) (string, time.Duration, error) {
+	key := purpose + ":" + subject
+
+	sealed, err := c.seal(key, data)
+	if err != nil {
+		return "", 0, err
+	}
+	return c.issue(ctx, key, sealed, false)
+}
+
+// Resend replaces the subject's code with a new one issued with the same data, e.g. when the first message
+// got lost. It returns ErrInvalidCode when there is no code to replace, and how long to wait like Issue.
+func (c *Codes) Resend(ctx context.Context, purpose, subject string) (string, time.Duration, error) {
+	key := purpose + ":" + subject
+
+	previous, ok, err := c.store.Get(ctx, key)
+	if err != nil {
+		return "", 0, err
+	}
+	if !ok {
+		return "", 0, ErrInvalidCode
+	}
+	if wait := c.resendWait(previous); wait > 0 {
+		return "", wait, nil
+	}
+	return c.issue(ctx, key, previous.Data, true)
+}
+
+// attemptsKey and codesKey name the subject's counters, which outlive its codes
+func attemptsKey(key string) string { return "attempts:" + key }
+func codesKey(key string) string    { return "codes:" + key }
+
+// resendWait returns how long until the entry may be replaced
+func (c *Codes) resendWait(entry Entry) time.Duration {
+	return max(time.Until(entry.IssuedAt.Add(c.cfg.ResendInterval)), 0)
+}
+
+// issue stores a new code for the key with data already sealed, replacing a pending one only if replace is
+// set. If the subject used up its codes, it returns how long until it gets new ones instead.
+func (c *Codes) issue(ctx context.Context, key string, sealed []byte, replace bool) (string, time.Duration, error) {
+	issued, reset, err := c.store.Count(ctx, codesKey(key), c.cfg.Window)
+	if err != nil {
+		return "", 0, err
+	}
+	if issued > c.cfg.MaxCodes {
+		return "", max(reset, time.Second), nil
+	}
+
+	n, err := rand.Int(rand.Reader, big.NewInt(1_000_000))
+	if err != nil {
+		return "", 0, err
+	}
+	code := fmt.Sprintf("%06d", n.Int64())
+
+	entry := Entry{Hash: hashCode(key, code), IssuedAt: time.Now(), Data: sealed}
+	if replace {
+		err = c.store.Put(ctx, key, entry, c.cfg.TTL)
+	} else {
+		var created bool
+		if created, err = c.store.Create(ctx, key, entry, c.cfg.TTL); err == nil && !created {
+			err = ErrPending
+		}
+	}
+	if err != nil {
+		return "", 0, err
+	}
+	return code, 0, nil
+}
+
+// Verify checks a code and uses it up, returning the data it was issued with. Every guess counts against
+// the subject before it is checked, so neither concurrent guesses nor new codes get past MaxAttempts; once
+// a wrong guess reaches it the code is dropped, and codes issued later in the window don't work either.
+func (c *Codes) Verify(ctx context.Context, purpose, subject, code string) ([]byte, error) {
+	key := purpose + ":" + subject
+
+	entry, ok, err := c.store.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrInvalidCode
+	}
+
+	attempts, _, err := c.store.Count(ctx, attemptsKey(key), c.cfg.Window)
+	if err != nil {
+		return nil, err
+	}
+	if attempts > c.cfg.MaxAttempts || subtle.ConstantTimeCompare([]byte(hashCode(key, code)), []byte(entry.Hash)) != 1 {
+		if attempts >= c.cfg.MaxAttempts {
+			if _, err := c.store.Delete(ctx, key); err != nil {
+				return nil, err
+			}
+		}
+		return nil, ErrInvalidCode
+	}
+
+	// Only the request that deletes the code gets to use it, so it can't be used twice concurrently
+	deleted, err := c.store.Delete(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if !deleted {
+		return nil, ErrInvalidCode
+	}
+	return c.open(key, entry.Data)
+}
+
+// seal encrypts data for the key, which it is bound to so it can't be moved to another subject's entry
+func (c *Codes) seal(key string, data []byte) ([]byte, error) {
+	nonce := make([]byte, c.aead.NonceSize(), c.aead.NonceSize()+len(data)+c.aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return c.aead.Seal(nonce, nonce, data, []byte(key)), nil
+}
+
+// open decrypts data sealed for the key
+func (c *Codes) open(key string, sealed []byte) ([]byte, error) {
+	if len(sealed) < c.aead.NonceSize() {
+		return nil, errors.New("verification data is too short")
+	}
+	nonce, ciphertext := sealed[:c.aead.NonceSize()], sealed[c.aead.NonceSize():]
+	return c.aead.Open(nil, nonce, ciphertext, []byte(key))
+}
+
+// hashCode binds the code to its key, so a hash can't be matched against another subject's code
+func hashCode(key, code string) string {
+	sum := sha256.Sum256([]byte(key + ":" + code))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,247 @@
+package verification
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/haiyen11231/eco-taxi-api-gateway/internal/redistest"
+)
+
+// stores returns a memory and a Redis store with no codes issued, so every test covers both
+func stores(t *testing.T) map[string]Store {
+	return map[string]Store{
+		"memory": NewMemoryStore(),
+		"redis":  NewRedisStore(redistest.NewClient(t), "test:verification:"),
+	}
+}
+
+func testConfig() Config {
+	return Config{TTL: time.Minute, MaxAttempts: 3, MaxCodes: 10, Window: time.Hour, ResendInterval: time.Millisecond}
+}
+
+func testCodes(t *testing.T, store Store) *Codes {
+	t.Helper()
+
+	c, err := New(testConfig(), store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// wrongCode returns a code other than code
+func wrongCode(code string) string {
+	if code == "000000" {
+		return "000001"
+	}
+	return "000000"
+}
+
+func TestCodesVerifyOnce(t *testing.T) {
+	ctx := context.Background()
+	for name, store := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			c := testCodes(t, store)
+
+			code, _, err := c.Issue(ctx, "signup", "0900000001", []byte("data"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := c.Verify(ctx, "signup", "0900000001", code)
+			if err != nil || string(data) != "data" {
+				t.Fatalf("Verify() = %q, %v", data, err)
+			}
+			if _, err := c.Verify(ctx, "signup", "0900000001", code); !errors.Is(err, ErrInvalidCode) {
+				t.Errorf("second Verify() error = %v, want %v", err, ErrInvalidCode)
+			}
+		})
+	}
+}
+
+func TestCodesEncryptData(t *testing.T) {
+	ctx := context.Background()
+	for name, store := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			key := bytes.Repeat([]byte{1}, 32)
+			cfg := testConfig()
+			cfg.DataKey = key
+			c, err := New(cfg, store)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			code, _, err := c.Issue(ctx, "signup", "0900000001", []byte(`{"password":"secret"}`))
+			if err != nil {
+				t.Fatal(err)
+			}
+			entry, _, _ := store.Get(ctx, "signup:0900000001")
+			if bytes.Contains(entry.Data, []byte("secret")) {
+				t.Fatalf("stored data %q holds the plaintext", entry.Data)
+			}
+
+			// Another instance with the same key can use the code, as can a resent one
+			other, _ := New(cfg, store)
+			time.Sleep(2 * cfg.ResendInterval)
+			code, _, err = other.Resend(ctx, "signup", "0900000001")
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := other.Verify(ctx, "signup", "0900000001", code)
+			if err != nil || string(data) != `{"password":"secret"}` {
+				t.Errorf("Verify() = %q, %v", data, err)
+			}
+		})
+	}
+}
+
+func TestCodesIssueOnceKeepsPendingData(t *testing.T) {
+	ctx := context.Background()
+	for name, store := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			c := testCodes(t, store)
+
+			if _, _, err := c.IssueOnce(ctx, "signup", "0900000001", []byte("owner")); err != nil {
+				t.Fatal(err)
+			}
+			time.Sleep(2 * time.Millisecond)
+			if _, _, err := c.IssueOnce(ctx, "signup", "0900000001", []byte("attacker")); !errors.Is(err, ErrPending) {
+				t.Fatalf("second IssueOnce() error = %v, want %v", err, ErrPending)
+			}
+
+			code, _, err := c.Resend(ctx, "signup", "0900000001")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if data, err := c.Verify(ctx, "signup", "0900000001", code); err != nil || string(data) != "owner" {
+				t.Errorf("Verify() = %q, %v, want the first registration", data, err)
+			}
+		})
+	}
+}
+
+func TestCodesDroppedAfterMaxAttempts(t *testing.T) {
+	ctx := context.Background()
+	for name, store := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			c := testCodes(t, store)
+
+			code, _, _ := c.Issue(ctx, "signup", "0900000001", nil)
+			for i := 0; i < 3; i++ {
+				if _, err := c.Verify(ctx, "signup", "0900000001", wrongCode(code)); !errors.Is(err, ErrInvalidCode) {
+					t.Fatalf("wrong guess %d error = %v", i, err)
+				}
+			}
+			if _, err := c.Verify(ctx, "signup", "0900000001", code); !errors.Is(err, ErrInvalidCode) {
+				t.Errorf("Verify() after max attempts error = %v, want %v", err, ErrInvalidCode)
+			}
+		})
+	}
+}
+
+func TestCodesRefuseGuessesPastMaxAttempts(t *testing.T) {
+	ctx := context.Background()
+	for name, store := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			c := testCodes(t, store)
+
+			// Other guesses still being checked have used up the attempts, so even the right code is refused
+			code, _, _ := c.Issue(ctx, "signup", "0900000001", nil)
+			for i := 0; i < 3; i++ {
+				store.Count(ctx, attemptsKey("signup:0900000001"), time.Hour)
+			}
+			if _, err := c.Verify(ctx, "signup", "0900000001", code); !errors.Is(err, ErrInvalidCode) {
+				t.Errorf("Verify() past max attempts error = %v, want %v", err, ErrInvalidCode)
+			}
+		})
+	}
+}
+
+func TestCodesNewCodeDoesNotRestoreAttempts(t *testing.T) {
+	ctx := context.Background()
+	for name, store := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			c := testCodes(t, store)
+
+			code, _, _ := c.Issue(ctx, "password-reset", "rider@example.com", nil)
+			for i := 0; i < 2; i++ {
+				c.Verify(ctx, "password-reset", "rider@example.com", wrongCode(code))
+			}
+
+			// A resent code only has the one guess left
+			time.Sleep(2 * time.Millisecond)
+			code, _, err := c.Resend(ctx, "password-reset", "rider@example.com")
+			if err != nil {
+				t.Fatal(err)
+			}
+			c.Verify(ctx, "password-reset", "rider@example.com", wrongCode(code))
+
+			// Neither does a code issued afresh once that one is dropped
+			time.Sleep(2 * time.Millisecond)
+			code, wait, err := c.Issue(ctx, "password-reset", "rider@example.com", nil)
+			if err != nil || wait != 0 {
+				t.Fatalf("Issue() wait = %v, err = %v", wait, err)
+			}
+			if _, err := c.Verify(ctx, "password-reset", "rider@example.com", code); !errors.Is(err, ErrInvalidCode) {
+				t.Errorf("Verify(right code) after the attempts ran out error = %v, want %v", err, ErrInvalidCode)
+			}
+		})
+	}
+}
+
+func TestCodesLimitIssuedCodes(t *testing.T) {
+	ctx := context.Background()
+	for name, store := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			cfg := testConfig()
+			cfg.MaxCodes = 2
+			c, _ := New(cfg, store)
+
+			for i := 0; i < 2; i++ {
+				if code, wait, err := c.Issue(ctx, "signup", "0900000001", nil); err != nil || wait != 0 || code == "" {
+					t.Fatalf("Issue() %d = %q, %v, %v", i, code, wait, err)
+				}
+				time.Sleep(2 * cfg.ResendInterval)
+			}
+			code, wait, err := c.Resend(ctx, "signup", "0900000001")
+			if err != nil || code != "" || wait <= time.Minute {
+				t.Errorf("Resend() past max codes = %q, %v, %v, want a wait until the window ends", code, wait, err)
+			}
+		})
+	}
+}
+
+func TestStoreCountIsAtomic(t *testing.T) {
+	ctx := context.Background()
+	for name, store := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			var (
+				wg   sync.WaitGroup
+				mu   sync.Mutex
+				seen = map[int]bool{}
+			)
+			for i := 0; i < 20; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					count, reset, err := store.Count(ctx, "key", time.Minute)
+					if err != nil || reset <= 0 || reset > time.Minute {
+						t.Errorf("Count() = %d, %v, %v", count, reset, err)
+						return
+					}
+					mu.Lock()
+					seen[count] = true
+					mu.Unlock()
+				}()
+			}
+			wg.Wait()
+
+			if len(seen) != 20 {
+				t.Errorf("concurrent counts got %d distinct values, want 20", len(seen))
+			}
+		})
+	}
+}
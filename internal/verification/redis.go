@@ -0,0 +1,95 @@
+package verification
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// countScript increments a counter, starting its expiry with the first increment, and returns it with its
+// remaining time to live in one round trip
+var countScript = redis.NewScript(`
+local count = redis.call("INCR", KEYS[1])
+if count == 1 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[1])
+end
+return {count, redis.call("PTTL", KEYS[1])}
+`)
+
+// createScript saves an entry only if the key has none, so concurrent creations can't replace each other
+var createScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 1 then
+	return 0
+end
+redis.call("HSET", KEYS[1], "hash", ARGV[1], "issued_at", ARGV[2], "data", ARGV[3])
+redis.call("PEXPIRE", KEYS[1], ARGV[4])
+return 1
+`)
+
+// RedisStore keeps the codes in Redis hashes, or any server speaking its protocol, so every gateway
+// instance of a cluster shares them. Entries expire on their own.
+type RedisStore struct {
+	client redis.UniversalClient
+	prefix string
+}
+
+// NewRedisStore returns a store keeping its keys under prefix, e.g. "eco-taxi:verification:".
+func NewRedisStore(client redis.UniversalClient, prefix string) *RedisStore {
+	return &RedisStore{client: client, prefix: prefix}
+}
+
+func (s *RedisStore) Put(ctx context.Context, key string, entry Entry, ttl time.Duration) error {
+	key = s.prefix + key
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, key)
+		pipe.HSet(ctx, key,
+			"hash", entry.Hash,
+			"issued_at", entry.IssuedAt.UnixMilli(),
+			"data", entry.Data,
+		)
+		pipe.PExpire(ctx, key, ttl)
+		return nil
+	})
+	return err
+}
+
+func (s *RedisStore) Create(ctx context.Context, key string, entry Entry, ttl time.Duration) (bool, error) {
+	created, err := createScript.Run(ctx, s.client, []string{s.prefix + key},
+		entry.Hash, entry.IssuedAt.UnixMilli(), entry.Data, ttl.Milliseconds()).Int()
+	if err != nil {
+		return false, err
+	}
+	return created == 1, nil
+}
+
+func (s *RedisStore) Get(ctx context.Context, key string) (Entry, bool, error) {
+	fields, err := s.client.HGetAll(ctx, s.prefix+key).Result()
+	if err != nil || len(fields) == 0 {
+		return Entry{}, false, err
+	}
+
+	issuedAt, err := strconv.ParseInt(fields["issued_at"], 10, 64)
+	if err != nil {
+		return Entry{}, false, err
+	}
+	return Entry{
+		Hash:     fields["hash"],
+		IssuedAt: time.UnixMilli(issuedAt),
+		Data:     []byte(fields["data"]),
+	}, true, nil
+}
+
+func (s *RedisStore) Count(ctx context.Context, key string, window time.Duration) (int, time.Duration, error) {
+	result, err := countScript.Run(ctx, s.client, []string{s.prefix + "count:" + key}, window.Milliseconds()).Int64Slice()
+	if err != nil {
+		return 0, 0, err
+	}
+	return int(result[0]), time.Duration(result[1]) * time.Millisecond, nil
+}
+
+func (s *RedisStore) Delete(ctx context.Context, key string) (bool, error) {
+	deleted, err := s.client.Del(ctx, s.prefix+key).Result()
+	return deleted > 0, err
+}
@@ -0,0 +1,133 @@
+package verification
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Entry is an issued code.
+type Entry struct {
+	Hash     string
+	IssuedAt time.Time
+	// Data is what the code unlocks, encrypted with Config.DataKey.
+	Data []byte
+}
+
+// Store keeps issued codes by key, and counters limiting what a subject can do across its codes. Implementations must be safe for concurrent use, and shared between
+// gateway instances when a code may be checked by another instance than the one that issued it.
+type Store interface {
+	// Put saves an entry for ttl, replacing any earlier one.
+	Put(ctx context.Context, key string, entry Entry, ttl time.Duration) error
+	// Create saves an entry for ttl unless the key has one that hasn't expired, reporting whether it did.
+	Create(ctx context.Context, key string, entry Entry, ttl time.Duration) (bool, error)
+	// Get returns the key's entry, if it has one that hasn't expired.
+	Get(ctx context.Context, key string) (Entry, bool, error)
+	// Count atomically increments the counter named key and returns it, so concurrent callers each get their
+	// own count, with how long until it resets. The counter expires window after its first increment.
+	Count(ctx context.Context, key string, window time.Duration) (int, time.Duration, error)
+	// Delete removes the key's entry, reporting whether there was one.
+	Delete(ctx context.Context, key string) (bool, error)
+}
+
+// MemoryStore keeps the codes in memory, for a single gateway instance.
+type MemoryStore struct {
+	mu       sync.Mutex
+	entries  map[string]memoryEntry
+	counters map[string]counter
+	sweptAt  time.Time
+}
+
+type memoryEntry struct {
+	Entry
+	expires time.Time
+}
+
+type counter struct {
+	count   int
+	expires time.Time
+}
+
+// NewMemoryStore returns an empty in-memory store.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{entries: map[string]memoryEntry{}, counters: map[string]counter{}}
+}
+
+func (s *MemoryStore) Put(_ context.Context, key string, entry Entry, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.sweep(now)
+	s.entries[key] = memoryEntry{Entry: entry, expires: now.Add(ttl)}
+	return nil
+}
+
+func (s *MemoryStore) Create(_ context.Context, key string, entry Entry, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.sweep(now)
+	if existing, ok := s.entries[key]; ok && !now.After(existing.expires) {
+		return false, nil
+	}
+	s.entries[key] = memoryEntry{Entry: entry, expires: now.Add(ttl)}
+	return true, nil
+}
+
+func (s *MemoryStore) Get(_ context.Context, key string) (Entry, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.entries[key]
+	if !ok || time.Now().After(entry.expires) {
+		return Entry{}, false, nil
+	}
+	return entry.Entry, true, nil
+}
+
+func (s *MemoryStore) Count(_ context.Context, key string, window time.Duration) (int, time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.sweep(now)
+
+	c, ok := s.counters[key]
+	if !ok || now.After(c.expires) {
+		c = counter{expires: now.Add(window)}
+	}
+	c.count++
+	s.counters[key] = c
+	return c.count, c.expires.Sub(now), nil
+}
+
+func (s *MemoryStore) Delete(_ context.Context, key string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.entries[key]
+	delete(s.entries, key)
+	return ok && !time.Now().After(entry.expires), nil
+}
+
+// sweep deletes the codes nobody entered before they expired and the guess and code counters whose window
+// has passed. Get and Count already ignore them; this frees their memory, on writes under s.mu, once a minute.
+func (s *MemoryStore) sweep(now time.Time) {
+	if now.Sub(s.sweptAt) < time.Minute {
+		return
+	}
+	s.sweptAt = now
+
+	for key, entry := range s.entries {
+		if now.After(entry.expires) {
+			delete(s.entries, key)
+		}
+	}
+	for key, c := range s.counters {
+		if now.After(c.expires) {
+			delete(s.counters, key)
+		}
+	}
+}